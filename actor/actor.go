@@ -9,30 +9,38 @@ import (
 	"github.com/eapache/channels"
 	"github.com/grrtrr/magicbus/aggregate"
 	"github.com/grrtrr/magicbus/event"
-	"github.com/pkg/errors"
+	"github.com/grrtrr/magicbus/fault"
 )
 
 // GLOBAL VARIABLES
 var logger = logrus.WithField("module", "actor")
 
 // ErrShutdown is returned if the actor is no longer accepting events/commands
-var ErrShutdown = errors.New("processing loop terminated")
+var ErrShutdown error = fault.New(fault.Unavailable, "processing loop terminated")
+
+// Number of internal errors buffered on the Err() channel before errors are dropped
+const errChanLen = 16
 
 // New instantiates a new actor in running state
 // @ctx:     top-level cancellation context
 // @cmdHdlr: called when a Command arrives on the Command Channel
 // @evtHdlr: called when an Event arrives on the Event Channel
 // @ready:   whether @cmdHdlr is ready to run immediately -  can be unblocked via ServiceReady{} event
-func New(ctx context.Context, cmdHdlr func(*aggregate.Command), evtHdlr func(event.Event), ready bool) Actor {
+// @opts:    optional settings, e.g. supervision of panics in @cmdHdlr/@evtHdlr
+func New(ctx context.Context, cmdHdlr func(*aggregate.Command), evtHdlr func(event.Event), ready bool, opts ...Option) Actor {
 	var a = &actor{
-		refcnt:      1, // new instances always start with a reference count of 1
-		actionChan:  make(chan func()),
-		errChan:     make(chan error),
-		eventChan:   channels.NewInfiniteChannel(),
-		commandChan: channels.NewInfiniteChannel(),
+		refcnt:     1, // new instances always start with a reference count of 1
+		actionChan: make(chan func()),
+		errChan:    make(chan error, errChanLen),
 	}
 	a.ctx, a.cancel = context.WithCancel(ctx)
 
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.eventChan = newMailbox(a.mailboxSize, a.overflow)
+	a.commandChan = newMailbox(a.mailboxSize, a.overflow)
+
 	if evtHdlr == nil {
 		panic("attempt to create Actor with nil Event Handler")
 	} else if cmdHdlr == nil {
@@ -46,10 +54,14 @@ func New(ctx context.Context, cmdHdlr func(*aggregate.Command), evtHdlr func(eve
 // actor is the internal implementation that provides the Actor interface
 type actor struct {
 	// Incoming commands
-	commandChan *channels.InfiniteChannel
+	commandChan channels.Channel
 
 	// Incoming events
-	eventChan *channels.InfiniteChannel
+	eventChan channels.Channel
+
+	// Maximum number of elements per mailbox (<= 0: unbounded), and what to do when full
+	mailboxSize int
+	overflow    OverflowPolicy
 
 	// Single action channel to process actions addressed to the Actor itself
 	actionChan chan func()
@@ -64,6 +76,14 @@ type actor struct {
 
 	// Any internal errors are published onto the error channel
 	errChan chan error
+
+	// Supervision of handler panics (all optional)
+	supervisor Supervisor
+	restart    func() error
+	escalate   func(Failure)
+
+	// Called with each message and the time it was enqueued, before handling it (optional)
+	dequeued func(msg interface{}, enqueued time.Time)
 }
 
 // Publish publishes @e onto the Event Bus of @a.
@@ -71,30 +91,41 @@ func (a *actor) Publish(e event.Event) error {
 	if !a.IsActive() {
 		return ErrShutdown
 	}
-	a.eventChan.In() <- e
-	return nil
+	return a.enqueue(a.ctx, a.eventChan, e)
 }
 
 // Submit submits @c onto the Command Bus of @a.
 func (a *actor) Submit(c *aggregate.Command) error {
 	if c == nil {
-		return errors.Errorf("attempt to submit a nil command")
+		return fault.New(fault.Rejected, "attempt to submit a nil command")
 	} else if !a.IsActive() {
 		return ErrShutdown
 	}
-	a.commandChan.In() <- c
-	return nil
+	return a.enqueue(c.Context(), a.commandChan, c)
 }
 
 // Action puts @action (to change @a's internal state) onto the internal commandbus.
 // Returns an error channel. Reading from channel causes synchronous processing.
+// If @a shuts down before accepting @action, the channel yields ErrShutdown.
 func (a *actor) Action(action func() error) <-chan error {
 	var errCh = make(chan error, 1)
 
 	if !a.IsActive() {
 		errCh <- ErrShutdown
-	} else {
-		a.actionChan <- func() { errCh <- action() }
+		return errCh
+	}
+
+	select {
+	case a.actionChan <- func() {
+		defer func() { // do not take down the loop, and do not leave the caller hanging
+			if r := recover(); r != nil {
+				errCh <- newPanicError(r)
+			}
+		}()
+		errCh <- action()
+	}:
+	case <-a.ctx.Done(): // the loop is no longer receiving
+		errCh <- ErrShutdown
 	}
 	return errCh
 }
@@ -109,6 +140,20 @@ func (a *actor) Err() <-chan error {
 	return a.errChan
 }
 
+// reportError publishes @err onto @errChan, dropping it if nobody is reading.
+func (a *actor) reportError(err error) {
+	select {
+	case a.errChan <- err:
+	default:
+		logger.Debugf("error channel full, dropping error: %s", err)
+	}
+}
+
+// QueueDepth returns the number of queued commands and events.
+func (a *actor) QueueDepth() (commands, events int) {
+	return a.commandChan.Len(), a.eventChan.Len()
+}
+
 // IsActive returns true if @a is still able to process events/commands
 func (a *actor) IsActive() bool {
 	select {
@@ -165,24 +210,26 @@ func (a *actor) loop(cmdHdlr func(*aggregate.Command), evtHdlr func(event.Event)
 		case e, ok := <-a.eventChan.Out():
 			if !ok || e == nil {
 				break
-			} else if evt, ok := e.(event.Event); !ok {
+			} else if evt, ok := a.dequeue(e).(event.Event); !ok {
 				logger.Errorf("non-Event %v on Event channel", e)
-				a.errChan <- errors.Errorf("non-Event %v on Event channel", e)
+				a.reportError(fault.New(fault.Internal, "non-Event %v on Event channel", e))
 			} else {
 				// The ServiceReady event serves to unblock the command channel.
-				if _, ok = e.(*event.ServiceReady); ok {
+				if _, ok = evt.(*event.ServiceReady); ok {
 					commandChan = a.commandChan.Out()
 				}
-				evtHdlr(evt)
+				if failure := supervise(func() { evtHdlr(evt) }, nil, evt); failure != nil {
+					a.recoverFrom(failure)
+				}
 			}
 		case c, ok := <-commandChan:
 			if !ok || c == nil {
 				break
-			} else if cmd, ok := c.(*aggregate.Command); !ok {
+			} else if cmd, ok := a.dequeue(c).(*aggregate.Command); !ok {
 				logger.Errorf("non-Command %v on Command channel", c)
-				a.errChan <- errors.Errorf("non-Command %v on Command channel", c)
-			} else {
-				cmdHdlr(cmd)
+				a.reportError(fault.New(fault.Internal, "non-Command %v on Command channel", c))
+			} else if failure := supervise(func() { cmdHdlr(cmd) }, cmd, nil); failure != nil {
+				a.recoverFrom(failure)
 			}
 		case <-a.ctx.Done(): // will be caught by a.IsActive()
 		}
@@ -204,7 +251,7 @@ func (a *actor) loop(cmdHdlr func(*aggregate.Command), evtHdlr func(event.Event)
 
 	close(a.errChan)
 
-	// Drain output channels to terminate the internal goroutines used by InfiniteChannel
+	// Drain output channels to terminate the internal goroutines used by Infinite/RingChannel
 	for range a.eventChan.Out() {
 	}
 	for range a.commandChan.Out() {
@@ -0,0 +1,239 @@
+package actor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+)
+
+// newPanicky returns an actor whose command handler panics on "panic" commands,
+// and reports all other commands on @handled.
+func newPanicky(handled chan<- string, opts ...Option) Actor {
+	return New(context.Background(),
+		func(cmd *aggregate.Command) {
+			if cmd.Type() == "panic" {
+				panic("command handler failed")
+			}
+			handled <- cmd.Type()
+		},
+		func(event.Event) {},
+		true, opts...,
+	)
+}
+
+func mkCommand(t *testing.T, typ string) *aggregate.Command {
+	cmd, err := aggregate.NewLocalCommand(aggregate.ID{Type: aggregate.ResourceType_CPU}, typ)
+	if err != nil {
+		t.Fatalf("failed to create %q command: %s", typ, err)
+	}
+	return cmd
+}
+
+// awaitPanic waits for the panic of @a to be reported on the error channel.
+func awaitPanic(t *testing.T, a Actor) {
+	select {
+	case err := <-a.Err():
+		if _, ok := err.(*PanicError); !ok {
+			t.Fatalf("expected a PanicError, got %T %s", err, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for panic to be reported")
+	}
+}
+
+func TestSupervisionResume(t *testing.T) {
+	var handled = make(chan string, 1)
+	var failures = make(chan Failure, 1)
+
+	a := newPanicky(handled, WithSupervisor(func(f Failure) Directive {
+		failures <- f
+		return Resume
+	}))
+	defer a.Shutdown()
+
+	panicCmd := mkCommand(t, "panic")
+	a.Submit(panicCmd)
+	awaitPanic(t, a)
+
+	if f := <-failures; f.Command != panicCmd {
+		t.Fatalf("failure does not report the failed command: %v", f.Command)
+	}
+
+	// The actor must continue processing after the panic.
+	a.Submit(mkCommand(t, "next"))
+	select {
+	case typ := <-handled:
+		if typ != "next" {
+			t.Fatalf("unexpected command %q", typ)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("actor stopped processing after panic")
+	}
+	if !a.IsActive() {
+		t.Fatalf("actor inactive after Resume")
+	}
+}
+
+func TestSupervisionRestartAndStop(t *testing.T) {
+	var handled = make(chan string, 1)
+	var restarted = make(chan struct{}, 1)
+
+	a := newPanicky(handled,
+		WithSupervisor(func(Failure) Directive { return Restart }),
+		WithRestart(func() error {
+			restarted <- struct{}{}
+			return nil
+		}),
+	)
+	defer a.Shutdown()
+
+	a.Submit(mkCommand(t, "panic"))
+	awaitPanic(t, a)
+
+	select {
+	case <-restarted:
+	case <-time.After(time.Second):
+		t.Fatalf("restart function not called")
+	}
+
+	// Stop and Escalate terminate the actor.
+	var escalated = make(chan Failure, 1)
+
+	b := newPanicky(handled,
+		WithSupervisor(func(Failure) Directive { return Escalate }),
+		WithEscalation(func(f Failure) { escalated <- f }),
+	)
+	b.Submit(mkCommand(t, "panic"))
+
+	select {
+	case <-escalated:
+	case <-time.After(time.Second):
+		t.Fatalf("failure not escalated")
+	}
+	for i := 0; b.IsActive(); i++ {
+		if i > 100 {
+			t.Fatalf("actor still active after Escalate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBoundedMailbox(t *testing.T) {
+	var handled = make(chan string, 10)
+	var newBlocked = func(policy OverflowPolicy) Actor { // commands are queued until ServiceReady
+		return New(context.Background(), func(cmd *aggregate.Command) { handled <- cmd.Type() },
+			func(event.Event) {}, false, WithMailbox(2, policy))
+	}
+
+	// 1. Reject
+	a := newBlocked(Reject)
+	defer a.Shutdown()
+
+	for i := 0; i < 2; i++ {
+		if err := a.Submit(mkCommand(t, "queued")); err != nil {
+			t.Fatalf("failed to submit command #%d: %s", i, err)
+		}
+	}
+	if err := a.Submit(mkCommand(t, "rejected")); err != ErrMailboxFull {
+		t.Fatalf("expected ErrMailboxFull, got %v", err)
+	} else if cmds, _ := a.QueueDepth(); cmds != 2 {
+		t.Fatalf("expected queue depth of 2, got %d", cmds)
+	}
+
+	// 2. Block, until the command is canceled
+	b := newBlocked(Block)
+	defer b.Shutdown()
+
+	b.Submit(mkCommand(t, "queued"))
+	b.Submit(mkCommand(t, "queued"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if cmd, _ := mkCommand(t, "blocked").WithContext(ctx); b.Submit(cmd) != context.DeadlineExceeded {
+		t.Fatalf("expected blocked Submit to time out")
+	}
+
+	// 3. DropOldest
+	c := newBlocked(DropOldest)
+	defer c.Shutdown()
+
+	for _, typ := range []string{"first", "second", "third"} {
+		if err := c.Submit(mkCommand(t, typ)); err != nil {
+			t.Fatalf("failed to submit %q: %s", typ, err)
+		}
+	}
+	c.Publish(&event.ServiceReady{})
+
+	for _, expected := range []string{"second", "third"} {
+		select {
+		case typ := <-handled:
+			if typ != expected {
+				t.Fatalf("expected %q, got %q", expected, typ)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+}
+
+func TestActionShutdown(t *testing.T) {
+	var release = make(chan struct{})
+	var result = make(chan error, 1)
+
+	a := New(context.Background(), func(*aggregate.Command) {}, func(event.Event) {}, true)
+
+	// The loop is busy with the first action, so that the second one can not be accepted.
+	started := make(chan struct{})
+	a.Action(func() error { close(started); <-release; return nil })
+	<-started
+	defer close(release)
+
+	go func() { result <- <-a.Action(func() error { return nil }) }()
+
+	// Shutting down releases the pending caller.
+	time.Sleep(20 * time.Millisecond)
+	a.Shutdown()
+
+	select {
+	case err := <-result:
+		if err != ErrShutdown {
+			t.Fatalf("expected ErrShutdown, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the pending action")
+	}
+}
+
+func TestQueueTiming(t *testing.T) {
+	var handled = make(chan string, 1)
+	var waited = make(chan time.Duration, 1)
+
+	a := New(context.Background(), func(cmd *aggregate.Command) { handled <- cmd.Type() }, func(event.Event) {}, false,
+		WithQueueTiming(func(msg interface{}, enqueued time.Time) {
+			if _, ok := msg.(*aggregate.Command); ok {
+				waited <- time.Since(enqueued)
+			}
+		}))
+	defer a.Shutdown()
+
+	// The command waits in the mailbox until the actor is ready.
+	if err := a.Submit(mkCommand(t, "queued")); err != nil {
+		t.Fatalf("failed to submit: %s", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	a.Publish(&event.ServiceReady{})
+
+	select {
+	case d := <-waited:
+		if d < 20*time.Millisecond {
+			t.Fatalf("expected to wait at least 20ms, got %s", d)
+		} else if typ := <-handled; typ != "queued" {
+			t.Fatalf("unexpected command %q", typ)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the command")
+	}
+}
@@ -0,0 +1,102 @@
+package actor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eapache/channels"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// ErrMailboxFull is returned by Submit/Publish if the mailbox is full and the overflow policy is Reject.
+var ErrMailboxFull error = fault.New(fault.Unavailable, "mailbox full")
+
+// OverflowPolicy determines what happens when a bounded mailbox is full.
+type OverflowPolicy int
+
+const (
+	// Block waits until there is space in the mailbox, the command is canceled, or the actor shuts down.
+	// NB: a handler that submits/publishes back into its own full actor deadlocks.
+	Block OverflowPolicy = iota
+
+	// Reject fails Submit/Publish with ErrMailboxFull
+	Reject
+
+	// DropOldest discards the oldest queued element to make space. This happens silently,
+	// i.e. the issuer of a discarded command is not notified.
+	DropOldest
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case Block:
+		return "Block"
+	case Reject:
+		return "Reject"
+	case DropOldest:
+		return "DropOldest"
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// newMailbox returns a channel holding up to @size elements, with overflow handled by @policy.
+// A @size <= 0 means unbounded.
+func newMailbox(size int, policy OverflowPolicy) channels.Channel {
+	switch {
+	case size <= 0:
+		return channels.NewInfiniteChannel()
+	case policy == DropOldest:
+		return channels.NewRingChannel(channels.BufferCap(size))
+	default:
+		return channels.NewNativeChannel(channels.BufferCap(size))
+	}
+}
+
+// enqueue puts @v into @mailbox, applying the overflow policy of @a.
+// @ctx: cancellation context of @v (for the Block policy)
+func (a *actor) enqueue(ctx context.Context, mailbox channels.Channel, v interface{}) error {
+	// Hold a reference while sending, so that the loop does not close @mailbox in the meantime.
+	a.incRefcnt()
+	defer a.decRefcnt()
+
+	if !a.IsActive() {
+		return ErrShutdown
+	} else if a.dequeued != nil {
+		v = queued{msg: v, enqueued: time.Now()}
+	}
+
+	if a.overflow == Reject {
+		select {
+		case mailbox.In() <- v:
+			return nil
+		default:
+			return ErrMailboxFull
+		}
+	}
+
+	// Block - unbounded and ring buffers never block for long.
+	select {
+	case mailbox.In() <- v:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-a.ctx.Done():
+		return ErrShutdown
+	}
+}
+
+// queued is a mailbox element which records when it was enqueued (see WithQueueTiming).
+type queued struct {
+	msg      interface{}
+	enqueued time.Time
+}
+
+// dequeue returns the message held by mailbox element @v, reporting its time in the mailbox if enabled.
+func (a *actor) dequeue(v interface{}) interface{} {
+	if q, ok := v.(queued); ok {
+		a.dequeued(q.msg, q.enqueued)
+		return q.msg
+	}
+	return v
+}
@@ -0,0 +1,45 @@
+package actor
+
+import "time"
+
+// Option configures an actor at construction time.
+type Option func(*actor)
+
+// WithSupervisor lets @s decide how to proceed when a command or event handler panics.
+// Without a supervisor, the actor resumes processing after a panic.
+func WithSupervisor(s Supervisor) Option {
+	return func(a *actor) {
+		a.supervisor = s
+	}
+}
+
+// WithRestart sets the function to re-initialize handler state after a Restart directive.
+// It is run within the actor loop.
+func WithRestart(restart func() error) Option {
+	return func(a *actor) {
+		a.restart = restart
+	}
+}
+
+// WithEscalation sets the function that is passed the Failure after an Escalate directive.
+func WithEscalation(escalate func(Failure)) Option {
+	return func(a *actor) {
+		a.escalate = escalate
+	}
+}
+
+// WithMailbox bounds the command and the event mailbox to @size elements each,
+// applying @policy when a mailbox is full. By default, mailboxes are unbounded.
+func WithMailbox(size int, policy OverflowPolicy) Option {
+	return func(a *actor) {
+		a.mailboxSize, a.overflow = size, policy
+	}
+}
+
+// WithQueueTiming calls @f with each command and event, and the time it was put into the mailbox,
+// right before handling it. This allows to measure the time spent waiting in the mailbox.
+func WithQueueTiming(f func(msg interface{}, enqueued time.Time)) Option {
+	return func(a *actor) {
+		a.dequeued = f
+	}
+}
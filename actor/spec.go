@@ -10,10 +10,12 @@ import (
 
 // Actor represents an self-contained actor, following Hewitt's Actor Model
 type Actor interface {
-	// Submit publishes @c onto the command bus
+	// Submit publishes @c onto the command bus.
+	// If the mailbox is bounded and full, the result depends on the OverflowPolicy.
 	Submit(*aggregate.Command) error
 
-	// Publish publishes @e onto the event bus
+	// Publish publishes @e onto the event bus.
+	// If the mailbox is bounded and full, the result depends on the OverflowPolicy.
 	Publish(event.Event) error
 
 	// Action attempts to submit an @action to the internal command bus
@@ -25,9 +27,16 @@ type Actor interface {
 	// IsActive returns true as long as the actor is able to accept commands/events
 	IsActive() bool
 
+	// QueueDepth returns the number of commands and events waiting to be processed
+	QueueDepth() (commands, events int)
+
 	// Refs returns the number of active references (>= 1: active, 0: dead)
 	Refs() uint32
 
 	// Context returns the internal context. Useful to add nested/child contexts.
 	Context() context.Context
+
+	// Err returns the channel on which internal errors (including recovered panics) are reported.
+	// Errors are dropped if the channel is not read; it is closed when the actor terminates.
+	Err() <-chan error
 }
@@ -0,0 +1,115 @@
+package actor
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// Directive tells the actor how to proceed after a handler has panicked.
+type Directive int
+
+const (
+	// Resume continues processing with the next command/event
+	Resume Directive = iota
+
+	// Restart re-initializes the handler state via the restart function, then resumes.
+	// If no restart function is configured, or it fails, the actor is stopped.
+	Restart
+
+	// Stop shuts the actor down
+	Stop
+
+	// Escalate stops the actor, and hands the failure to the escalation function of the parent
+	Escalate
+)
+
+func (d Directive) String() string {
+	switch d {
+	case Resume:
+		return "Resume"
+	case Restart:
+		return "Restart"
+	case Stop:
+		return "Stop"
+	case Escalate:
+		return "Escalate"
+	}
+	return fmt.Sprintf("Directive(%d)", int(d))
+}
+
+// Failure describes a panic in a command or event handler.
+type Failure struct {
+	Err     *PanicError        // The recovered panic
+	Command *aggregate.Command // The command being handled (nil if Event is set)
+	Event   event.Event        // The event being handled (nil if Command is set)
+}
+
+// Supervisor decides how to proceed after a Failure.
+type Supervisor func(Failure) Directive
+
+// PanicError is the error value of a recovered panic.
+type PanicError struct {
+	Value interface{} // Value passed to panic()
+	Stack []byte      // Stack trace at the time of the panic
+}
+
+// FaultCode classifies panics as internal errors.
+func (p *PanicError) FaultCode() fault.Code {
+	return fault.Internal
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", p.Value)
+}
+
+// newPanicError captures the stack trace of the recovered panic @value.
+func newPanicError(value interface{}) *PanicError {
+	return &PanicError{Value: value, Stack: debug.Stack()}
+}
+
+// supervise runs @hdlr, and returns a non-nil Failure if @hdlr panicked.
+func supervise(hdlr func(), cmd *aggregate.Command, evt event.Event) (failure *Failure) {
+	defer func() {
+		if r := recover(); r != nil {
+			failure = &Failure{Err: newPanicError(r), Command: cmd, Event: evt}
+		}
+	}()
+	hdlr()
+	return nil
+}
+
+// recoverFrom applies the directive of the supervisor of @a to @failure.
+func (a *actor) recoverFrom(failure *Failure) {
+	var directive = Resume
+
+	logger.Errorf("handler failed: %s\n%s", failure.Err, failure.Err.Stack)
+	a.reportError(failure.Err)
+
+	if a.supervisor != nil {
+		directive = a.supervisor(*failure)
+	}
+
+	switch directive {
+	case Resume:
+	case Restart:
+		if a.restart == nil {
+			logger.Errorf("unable to restart: no restart function configured - stopping")
+			a.cancel()
+		} else if err := a.restart(); err != nil {
+			logger.Errorf("failed to restart: %s - stopping", err)
+			a.reportError(err)
+			a.cancel()
+		}
+	case Escalate:
+		if a.escalate != nil {
+			go a.escalate(*failure)
+		}
+		fallthrough
+	default: // Stop
+		a.cancel()
+	}
+}
@@ -6,8 +6,12 @@ type Aggregate interface {
 	AggregateID() ID
 
 	// HandleCommand lets the Aggregate handle @Command
-	// @next:   if not nil, returns next command-in-sequence to complete
+	// @next:   if not nil, returns next command-in-sequence to complete (see also NewFanOut);
+	//         the issuer is notified only once the next command (tree) has completed, too
 	// @result: (only if @next=nil) returns result of operation
 	// @err:    error value (@next/@result are ignored in this case)
 	HandleCommand(*Command) (next *Command, result interface{}, err error)
 }
+
+// Factory creates the Aggregate identified by @id.
+type Factory func(id ID) (Aggregate, error)
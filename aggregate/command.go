@@ -7,10 +7,14 @@ import (
 	"reflect"
 
 	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
 )
 
 // Command is an implementation of command.Command
 type Command struct {
+	// id uniquely identifies this command (and correlates it with its CommandDone event)
+	id string
+
 	// dst designates the receiver of this command
 	dst ID
 
@@ -22,6 +26,9 @@ type Command struct {
 
 	// Cancellation context
 	ctx context.Context
+
+	// Metadata headers (may be nil)
+	meta Metadata
 }
 
 // WithContext adds @ctx to @c and returns the transformed result and cancel function.
@@ -30,6 +37,38 @@ func (c *Command) WithContext(ctx context.Context) (c1 *Command, cancel context.
 	return c, cancel
 }
 
+// WithMetadata adds the entries of @md to the metadata of @c, replacing existing ones, and returns @c.
+func (c *Command) WithMetadata(md Metadata) *Command {
+	c.meta = md.Inherit(c.meta)
+	return c
+}
+
+// InheritMetadata completes the metadata of @c with those entries of @md which @c does not have, and returns @c.
+func (c *Command) InheritMetadata(md Metadata) *Command {
+	c.meta = c.meta.Inherit(md)
+	return c
+}
+
+// CorrelationID returns the ID of the command which started the chain that @c belongs to.
+// A command without a correlation ID in its metadata starts a chain of its own.
+func (c *Command) CorrelationID() string {
+	if id := c.meta.CorrelationID(); id != "" {
+		return id
+	}
+	return c.id
+}
+
+// CausationID returns the ID of the command which caused @c ("" if @c starts a chain).
+func (c *Command) CausationID() string {
+	return c.meta.CausationID()
+}
+
+// CausedMetadata returns the metadata of the commands and events caused by @c: the metadata of @c,
+// with @c as their cause, and the same correlation ID as @c.
+func (c *Command) CausedMetadata() Metadata {
+	return Metadata{CorrelationKey: c.CorrelationID(), CausationKey: c.id}.Inherit(c.meta)
+}
+
 // NewLocalCommand is the simplest use case: local aggregate, no job tracking.
 func NewLocalCommand(aggregate ID, cmdData interface{}) (*Command, error) {
 	return NewCommand(aggregate, aggregate, cmdData)
@@ -41,8 +80,18 @@ func NewLocalCommand(aggregate ID, cmdData interface{}) (*Command, error) {
 //            a) a (pointer to a) struct - in this case Type() is the type-name of the struct, or
 //            b) a non-empty string - in this case Type() is the content of the string.
 func NewCommand(src, dst ID, cmdData interface{}) (*Command, error) {
+	return NewCommandWithID(uuid.NewV1().String(), src, dst, cmdData)
+}
+
+// NewCommandWithID is like NewCommand, but uses @id as command ID.
+// This is used to restore commands which were created elsewhere (e.g. on a remote node).
+func NewCommandWithID(id string, src, dst ID, cmdData interface{}) (*Command, error) {
 	var t = getType(cmdData)
 
+	if id == "" {
+		return nil, errors.Errorf("attempt to submit a command with an empty ID")
+	}
+
 	if t == nil {
 		return nil, errors.Errorf("attempt to submit a nil command")
 	}
@@ -60,7 +109,7 @@ func NewCommand(src, dst ID, cmdData interface{}) (*Command, error) {
 		return nil, errors.Errorf("attempt to submit %s %#+v as command", t.Kind(), cmdData)
 	}
 
-	return &Command{src: src, dst: dst, args: cmdData, ctx: context.Background()}, nil
+	return &Command{id: id, src: src, dst: dst, args: cmdData, ctx: context.Background()}, nil
 
 }
 
@@ -78,9 +127,11 @@ func (c *Command) ToJSON() string {
 }
 
 // Getters (no Setters)
+func (c *Command) ID() string               { return c.id }
 func (c *Command) Source() ID               { return c.src }
 func (c *Command) Dest() ID                 { return c.dst }
 func (c *Command) Context() context.Context { return c.ctx }
+func (c *Command) Metadata() Metadata       { return c.meta }
 
 // Implements command.Command
 func (c *Command) Data() interface{} { return c.args }
@@ -73,3 +73,39 @@ type namedCommand2 struct {
 	foo, bar []byte
 	field3   int
 }
+
+func TestCommandMetadata(t *testing.T) {
+	c, _ := simpleNewCommand(ID{Type: 42}, "reset")
+
+	if c.Metadata() != nil {
+		t.Fatalf("expected no metadata, got %v", c.Metadata())
+	}
+
+	c.WithMetadata(Metadata{"user": "alice", "tenant": "acme"}).WithMetadata(Metadata{"user": "bob"})
+	c.InheritMetadata(Metadata{"user": "carol", "priority": "high"})
+
+	for k, v := range map[string]string{"user": "bob", "tenant": "acme", "priority": "high"} {
+		if c.Metadata()[k] != v {
+			t.Fatalf("expected %s = %q, got %v", k, v, c.Metadata())
+		}
+	}
+}
+
+func TestCommandCausation(t *testing.T) {
+	root, _ := simpleNewCommand(ID{Type: 42}, "provision")
+	if root.CorrelationID() != root.ID() || root.CausationID() != "" {
+		t.Fatalf("expected %s to start a chain", root)
+	}
+
+	next, _ := simpleNewCommand(ID{Type: 42}, "reserve")
+	next.InheritMetadata(root.CausedMetadata())
+	if next.CorrelationID() != root.ID() || next.CausationID() != root.ID() {
+		t.Fatalf("expected %s to be caused by %s", next, root)
+	}
+
+	last, _ := simpleNewCommand(ID{Type: 42}, "finish")
+	last.InheritMetadata(next.CausedMetadata())
+	if last.CorrelationID() != root.ID() || last.CausationID() != next.ID() {
+		t.Fatalf("expected %s to be caused by %s", last, next)
+	}
+}
@@ -0,0 +1,25 @@
+package aggregate
+
+import "github.com/pkg/errors"
+
+// FanOut is the data of a next-step command which runs several commands in parallel (see NewFanOut).
+type FanOut struct {
+	Branches []*Command // Commands to run in parallel
+	Join     *Command   // Command to run after all Branches have succeeded (may be nil)
+}
+
+// NewFanOut returns a next step (see Aggregate.HandleCommand) which runs @branches in parallel,
+// followed by @join (may be nil) once all of them have succeeded.
+// The issuer of the original command is notified only after the whole tree of commands has completed.
+// @src: Aggregate issuing the fan-out
+func NewFanOut(src ID, join *Command, branches ...*Command) (*Command, error) {
+	if len(branches) == 0 {
+		return nil, errors.Errorf("attempt to fan out to no commands")
+	}
+	for _, b := range branches {
+		if b == nil {
+			return nil, errors.Errorf("attempt to fan out to a nil command")
+		}
+	}
+	return NewCommand(src, src, FanOut{Branches: branches, Join: join})
+}
@@ -0,0 +1,37 @@
+package aggregate
+
+// Metadata holds the headers of a command, e.g. user identity, tenant, trace context or priority.
+// It propagates to the CommandDone event of the command, to the events emitted while handling it,
+// and to its follow-up commands. Metadata is shared, i.e. it must not be modified once attached.
+type Metadata map[string]string
+
+// Metadata keys which link commands and events to their origin
+const (
+	// ID of the command which started a chain of commands and events
+	CorrelationKey = "correlation-id"
+
+	// ID of the command which directly caused a command or event
+	CausationKey = "causation-id"
+)
+
+// CorrelationID returns the correlation ID recorded in @m ("" if none).
+func (m Metadata) CorrelationID() string { return m[CorrelationKey] }
+
+// CausationID returns the causation ID recorded in @m ("" if none).
+func (m Metadata) CausationID() string { return m[CausationKey] }
+
+// Inherit returns a copy of @m, completed with those entries of @parent which @m does not have.
+func (m Metadata) Inherit(parent Metadata) Metadata {
+	if len(m) == 0 && len(parent) == 0 {
+		return nil
+	}
+
+	var md = make(Metadata, len(m)+len(parent))
+	for k, v := range parent {
+		md[k] = v
+	}
+	for k, v := range m {
+		md[k] = v
+	}
+	return md
+}
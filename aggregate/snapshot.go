@@ -0,0 +1,11 @@
+package aggregate
+
+// Snapshotter is implemented by Aggregates whose state can be saved when they are passivated,
+// and restored when they are reactivated.
+type Snapshotter interface {
+	// Snapshot returns a copy of the current state of the Aggregate
+	Snapshot() (interface{}, error)
+
+	// Restore resets the state of a freshly created Aggregate to @snapshot
+	Restore(snapshot interface{}) error
+}
@@ -1,60 +1,223 @@
 package magicbus
 
 import (
-	"context"
+	"time"
 
 	"github.com/grrtrr/magicbus/actor"
 	"github.com/grrtrr/magicbus/aggregate"
 	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/trace"
 )
 
 // aggregateActor serializes command/event handling on behalf of a registered Aggregate
 type aggregateActor struct {
+	// Time of the last hand-off of a command or event by the bus (UnixNano, atomic).
+	// Atomically accessed fields come first, to guarantee 64-bit alignment.
+	lastActive int64
+
+	// Number of commands and events handed off by the bus so far (atomic)
+	handoffs uint64
+
 	// Aggregate handled by this actor
 	aggregate.Aggregate
 
 	// Internal actor object
 	actor.Actor
+
+	// ID of the Aggregate (fixed, since the Aggregate may be replaced on restart)
+	id aggregate.ID
+
+	// Bus which the Aggregate is registered with
+	bus *MagicBus
+
+	// Whether the Aggregate is event-sourced, i.e. the sole writer of its event stream
+	sourced bool
+
+	// Registration settings of the Aggregate
+	registration
+}
+
+// RegisterOption configures how the bus handles a registered Aggregate.
+type RegisterOption func(*registration)
+
+// registration collects the settings of a registered Aggregate.
+type registration struct {
+	// Directive applied when HandleCommand/HandleEvent panics
+	supervision actor.Directive
+
+	// Re-creates the Aggregate on a Restart directive, or on reactivation (may be nil).
+	// The sourcedFactory of event-sourced Aggregates takes precedence.
+	factory        aggregate.Factory
+	sourcedFactory event.SourcedFactory
+
+	// Bound of each mailbox (<= 0: unbounded), and what to do when full
+	mailboxSize int
+	overflow    actor.OverflowPolicy
+
+	// Idle time after which the Aggregate is passivated (0: never)
+	idleTimeout time.Duration
+
+	// Interceptor chains of the Aggregate, outermost first
+	commandInterceptors []CommandInterceptor
+	deliverInterceptors []DeliverInterceptor
+}
+
+// WithSupervision sets the Directive applied when a handler of the Aggregate panics (default: Resume).
+// In all cases, the command being handled fails with the panic as error.
+// An Escalate directive removes the Aggregate from the bus.
+func WithSupervision(d actor.Directive) RegisterOption {
+	return func(r *registration) {
+		r.supervision = d
+	}
+}
+
+// WithFactory sets the Factory which re-creates the Aggregate on a Restart directive.
+func WithFactory(f aggregate.Factory) RegisterOption {
+	return func(r *registration) {
+		r.factory = f
+	}
+}
+
+// WithSourcedFactory sets the SourcedFactory which re-creates an event-sourced Aggregate on a Restart
+// directive, or on reactivation after passivation. The new instance is rehydrated from its history.
+func WithSourcedFactory(f event.SourcedFactory) RegisterOption {
+	return func(r *registration) {
+		r.sourcedFactory = f
+	}
+}
+
+// WithAggregateMailbox bounds the command and event mailboxes of the Aggregate to @size elements each.
+// When full, the bus applies @policy; commands rejected by the Aggregate are dead-lettered.
+// NB: actor.Block makes the bus wait for the Aggregate, i.e. a slow Aggregate slows down the bus.
+func WithAggregateMailbox(size int, policy actor.OverflowPolicy) RegisterOption {
+	return func(r *registration) {
+		r.mailboxSize, r.overflow = size, policy
+	}
+}
+
+// WithPassivation shuts the Aggregate down after it has not received any commands or events for @idle.
+// It is reactivated via its factory (see WithFactory, RegisterFactory) when the next message arrives.
+// If the Aggregate is an aggregate.Snapshotter, its state is saved on passivation and restored
+// on reactivation. Reactivated Aggregates are always ready to process commands.
+func WithPassivation(idle time.Duration) RegisterOption {
+	return func(r *registration) {
+		r.idleTimeout = idle
+	}
+}
+
+// WithAggregateInterceptors adds @interceptors to the handling of commands by the Aggregate.
+func WithAggregateInterceptors(interceptors ...CommandInterceptor) RegisterOption {
+	return func(r *registration) {
+		r.commandInterceptors = append(r.commandInterceptors, interceptors...)
+	}
+}
+
+// WithAggregateDeliverInterceptors adds @interceptors to the delivery of events to the Aggregate.
+func WithAggregateDeliverInterceptors(interceptors ...DeliverInterceptor) RegisterOption {
+	return func(r *registration) {
+		r.deliverInterceptors = append(r.deliverInterceptors, interceptors...)
+	}
 }
 
 // newAggregateActor returns an initialized new Actor
-// @ctx:    Cancellation context
-// @agg:    Aggregate represented by this aggregateActor
-// @ready:  Whether @agg is ready to run its HandleCommand() function.
-//          If set to false, can be enabled later by sending a ServiceReady event.
-func newAggregateActor(ctx context.Context, agg aggregate.Aggregate, ready bool) *aggregateActor {
-	a := &aggregateActor{Aggregate: agg}
-
-	a.Actor = actor.New(ctx, a.commandHandler, a.eventHandler, ready)
+// @bus:   Bus which @agg is registered with (also provides the cancellation context)
+// @agg:   Aggregate represented by this aggregateActor
+// @ready: Whether @agg is ready to run its HandleCommand() function.
+//         If set to false, can be enabled later by sending a ServiceReady event.
+// @reg:   Registration settings
+func newAggregateActor(bus *MagicBus, agg aggregate.Aggregate, ready bool, reg registration) *aggregateActor {
+	a := &aggregateActor{Aggregate: agg, id: agg.AggregateID(), bus: bus, registration: reg}
+	_, a.sourced = agg.(*sourcedAggregate)
+	opts := []actor.Option{
+		actor.WithSupervisor(a.supervise),
+		actor.WithRestart(a.restart),
+		actor.WithEscalation(func(failure actor.Failure) { bus.escalated(a, failure) }),
+		actor.WithMailbox(reg.mailboxSize, reg.overflow),
+	}
+	if bus.tracer != nil {
+		opts = append(opts, bus.queueTiming(a.id.String()))
+	}
+
+	a.Actor = actor.New(bus.Context(), a.commandHandler, a.eventHandler, ready, opts...)
 	return a
 }
 
+// AggregateID returns the ID of the Aggregate handled by @a.
+func (a *aggregateActor) AggregateID() aggregate.ID {
+	return a.id
+}
+
 // command-processing callback
 func (a *aggregateActor) commandHandler(cmd *aggregate.Command) {
 	var agId = a.AggregateID()
 
 	// The Dest of a command identifies the matching aggregate, with the only exception
-	// that a specific command (ID != "") is sent to the "general manager" (ID == "").
+	// that a specific command (ID != "") is sent to the "general manager" (ID == "")
+	// if neither a specific aggregate nor a factory for it is registered (see RegisterFactory).
 	if cmd.Dest() != agId && (agId.ID != "" || cmd.Dest().Type != agId.Type || cmd.Dest().Node != agId.Node) {
 		logger.Errorf("%s: refusing to handle command - mismatching aggregate ID %s", a.AggregateID(), cmd.Dest())
 		return
 	} else if err := cmd.Context().Err(); err != nil {
 		logger.Warningf("%s: command canceled (%s)", a.AggregateID(), err)
+		a.bus.forgetAttempts(cmd)
+		return
+	}
+	nextStep, result, err := a.handleCommand(cmd)
+
+	// Failed commands may be retried, in which case completion is reported after the last attempt.
+	attempts, retrying := a.bus.retry(cmd, err)
+	if retrying {
 		return
 	}
-	nextStep, result, err := a.Aggregate.HandleCommand(cmd)
 
 	// Emit the CommandDone event to notify the (remote) site of completion.
-	// Note: agId and cmd.Dest() may differ in the case where a new, specific Aggregate is created.
-	//       In this case, agID.ID=="" and cmdID.ID != "", and we have created a new Aggregate to
-	//       handle the event. Thus, the _actual_ source Aggregate is cmd.Dest().
-	//       If ever changing the creation of specific managers, this MUST also be updated.
-	Publish(event.NewCmdDone(cmd.Dest() /* see comment above */, cmd, result, err))
+	// Note: agId and cmd.Dest() differ when the general manager (agId.ID == "") handles a command
+	//       for a specific entity (cmd.Dest().ID != ""), e.g. by creating it. Thus, the _actual_
+	//       source Aggregate is cmd.Dest(). Aggregates created via RegisterFactory have agId == cmd.Dest().
+	cd := event.NewCmdDone(cmd.Dest() /* see comment above */, cmd, result, err).(*event.CommandDone)
+	cd.Attempts = attempts
+
+	// The issuer is notified once the nextStep command (tree) has completed, too.
+	if err == nil && nextStep != nil {
+		go a.followUp(cmd, cd, nextStep.InheritMetadata(cmd.CausedMetadata()))
+	} else {
+		a.publish(cd)
+	}
+}
+
+// followUp runs @next, the next step of @cmd, and publishes the completion @cd of @cmd once @next has completed.
+// Failed follow-up commands are reported in cd.Branches, and leave the outcome of @cmd itself unchanged.
+func (a *aggregateActor) followUp(cmd *aggregate.Command, cd *event.CommandDone, next *aggregate.Command) {
+	ctx, cancel := a.bus.followUpContext(cmd)
+	defer cancel()
 
-	// Submit the nextStep command only _after_ publishing the events (otherwise the timing is off).
-	if nextStep != nil {
-		Submit(cmd.Context(), nextStep)
+	cd.Branches = a.bus.runFollowUp(ctx, next)
+	if err := cd.Result().BranchErr(); err != nil {
+		logger.Warningf("%s: follow-up of %s failed: %s", a.AggregateID(), cmd, err)
 	}
+	a.publish(cd)
+}
+
+// handleCommand passes @cmd through the interceptors of the bus and of @a, on to the Aggregate.
+// The next step of @cmd continues the trace of its handling.
+func (a *aggregateActor) handleCommand(cmd *aggregate.Command) (*aggregate.Command, interface{}, error) {
+	var interceptors = append(append([]CommandInterceptor(nil), a.bus.commandInterceptors...), a.commandInterceptors...)
+
+	span := a.bus.startCommandSpan(trace.Extract(cmd.Metadata()), cmd, "handle")
+	span.SetAttribute("aggregate", a.id.String())
+	defer span.End()
+
+	// Events published on behalf of cmd.Dest() in the meantime inherit the metadata of @cmd.
+	a.bus.handling.Store(cmd.Dest(), cmd)
+	defer a.bus.handling.Delete(cmd.Dest())
+
+	// NB: the Aggregate may be replaced on restart, hence the chain ends in a.Aggregate at the time of the call.
+	next, result, err := chainCommand(interceptors, a.Aggregate.HandleCommand)(cmd)
+	span.SetError(err)
+	if next != nil && span.Context().IsValid() {
+		next.WithMetadata(trace.Inject(span.Context()))
+	}
+	return next, result, err
 }
 
 // eventHandler is called by a.actor for each incoming event e whose Dest() matches the AggregateID of @a.
@@ -62,6 +225,41 @@ func (a *aggregateActor) eventHandler(e event.Event) {
 	if _, ok := e.(*event.ServiceReady); ok { // ServiceReady events are not passed on any further.
 		logger.Debugf("%s: ready to process commands", a.AggregateID())
 	} else if eh, ok := a.Aggregate.(event.EventHandler); ok {
-		eh.HandleEvent(e)
+		interceptors := append(append([]DeliverInterceptor(nil), a.bus.deliverInterceptors...), a.deliverInterceptors...)
+		chainDeliver(interceptors, eh.HandleEvent)(e)
 	}
 }
+
+// publish passes @e on to the bus of @a.
+func (a *aggregateActor) publish(e event.Event) {
+	if err := a.bus.Publish(e); err != nil && err != actor.ErrShutdown {
+		logger.Errorf("%s: failed to publish %v: %s", a.AggregateID(), e, err)
+	}
+}
+
+// supervise is called by a.actor when a handler of the Aggregate panicked.
+func (a *aggregateActor) supervise(failure actor.Failure) actor.Directive {
+	logger.Errorf("%s: %s - applying %s", a.AggregateID(), failure.Err, a.supervision)
+
+	// Notify the issuer that the command failed, unless it is retried.
+	if failure.Command != nil {
+		if attempts, retrying := a.bus.retry(failure.Command, failure.Err); !retrying {
+			cd := event.NewCmdDone(failure.Command.Dest(), failure.Command, nil, failure.Err).(*event.CommandDone)
+			cd.Attempts = attempts
+			a.publish(cd)
+		}
+	}
+	return a.supervision
+}
+
+// restart replaces the Aggregate handled by @a with a fresh instance from the factory.
+func (a *aggregateActor) restart() error {
+	agg, err := a.bus.create(a.AggregateID(), a.registration)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("%s: restarted", a.AggregateID())
+	a.Aggregate = agg
+	return nil
+}
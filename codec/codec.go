@@ -0,0 +1,162 @@
+// Package codec translates Commands and Events to and from a self-describing, versioned envelope,
+// so that they can be sent to remote buses or persisted, and later restored to their concrete Go type.
+//
+// Decoding requires the concrete types to be known: command payloads are registered via
+// RegisterCommand(), events via RegisterEvent(), command results via RegisterResult(),
+// all keyed by their type name.
+package codec
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// GLOBAL VARIABLES
+var logger = logrus.WithField("module", "codec")
+
+// Version of the envelope format produced by this package
+const Version = 1
+
+// Kind distinguishes command from event envelopes.
+type Kind string
+
+const (
+	KindCommand Kind = "command"
+	KindEvent   Kind = "event"
+)
+
+// Envelope is the wire/storage representation of a Command or an Event.
+type Envelope struct {
+	Version  int               `json:"v"`
+	Kind     Kind              `json:"kind"`
+	Type     string            `json:"type"`         // Command.Type() or event type name
+	ID       string            `json:"id,omitempty"` // Command.ID() (commands only)
+	Src      aggregate.ID      `json:"src"`
+	Dst      aggregate.ID      `json:"dst"`
+	Payload  json.RawMessage   `json:"payload,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Registry of known types
+var registry = struct {
+	sync.RWMutex
+	commands map[string]reflect.Type // map { Command.Type() -> type of Command.Data() }
+	events   map[string]reflect.Type // map { event type name -> event type }
+	results  map[string]reflect.Type // map { result type name -> result type }
+}{
+	commands: map[string]reflect.Type{},
+	events:   map[string]reflect.Type{},
+	results:  map[string]reflect.Type{},
+}
+
+func init() {
+	// Events defined by the bus itself
+	for _, e := range []event.Event{&event.CommandDone{}, &event.ServiceReady{}} {
+		if err := RegisterEvent(e); err != nil {
+			panic(err)
+		}
+	}
+
+	// Predeclared result types
+	for _, r := range []interface{}{"", false, 0, int64(0), uint(0), uint64(0), float64(0)} {
+		if err := RegisterResult(r); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterCommand registers the (pointer to a) named struct @cmdData as command payload type.
+// Commands whose payload is a string do not need to be registered.
+func RegisterCommand(cmdData interface{}) error {
+	cmd, err := aggregate.NewLocalCommand(aggregate.ID{}, cmdData)
+	if err != nil {
+		return err
+	} else if reflect.TypeOf(cmdData).Kind() == reflect.String {
+		return fault.New(fault.Rejected, "string command %q does not need to be registered", cmdData)
+	}
+	return register(registry.commands, cmd.Type(), reflect.TypeOf(cmdData))
+}
+
+// RegisterEvent registers the type of @e, so that envelopes of this type can be decoded.
+func RegisterEvent(e event.Event) error {
+	if e == nil {
+		return fault.New(fault.Rejected, "attempt to register a nil event")
+	}
+	return register(registry.events, EventType(e), reflect.TypeOf(e))
+}
+
+// register adds @name => @typ to @types, rejecting conflicting registrations.
+func register(types map[string]reflect.Type, name string, typ reflect.Type) error {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if t, ok := types[name]; ok && t != typ {
+		return fault.New(fault.Conflict, "type name %q already registered for %s", name, t)
+	}
+	types[name] = typ
+	return nil
+}
+
+// lookup returns the type registered under @name in @types.
+func lookup(types map[string]reflect.Type, name string) (reflect.Type, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	t, ok := types[name]
+	return t, ok
+}
+
+// EventType returns the type name of @e, which is used as Envelope.Type.
+func EventType(e event.Event) string {
+	var t = reflect.TypeOf(e)
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// newValue decodes @payload into a new value of type @t.
+func newValue(t reflect.Type, payload json.RawMessage) (interface{}, error) {
+	var v reflect.Value
+
+	if t.Kind() == reflect.Ptr {
+		v = reflect.New(t.Elem())
+	} else {
+		v = reflect.New(t)
+	}
+
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, v.Interface()); err != nil {
+			return nil, fault.Wrapf(err, fault.Rejected, "failed to decode %s payload", t)
+		}
+	}
+
+	if t.Kind() == reflect.Ptr {
+		return v.Interface(), nil
+	}
+	return v.Elem().Interface(), nil
+}
+
+// Marshal serializes @env.
+func Marshal(env *Envelope) ([]byte, error) {
+	return json.Marshal(env)
+}
+
+// Unmarshal deserializes an envelope from @data, checking that its version is supported.
+func Unmarshal(data []byte) (*Envelope, error) {
+	var env = new(Envelope)
+
+	if err := json.Unmarshal(data, env); err != nil {
+		return nil, fault.Wrapf(err, fault.Rejected, "failed to decode envelope")
+	} else if env.Version < 1 || env.Version > Version {
+		return nil, fault.New(fault.Rejected, "unsupported envelope version %d", env.Version)
+	}
+	return env, nil
+}
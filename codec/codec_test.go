@@ -0,0 +1,154 @@
+package codec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+type allocateMemory struct {
+	Bank  string
+	Bytes uint64
+}
+
+type memoryAllocated struct {
+	Bank string
+	Src  aggregate.ID
+	Dst  aggregate.ID
+}
+
+func (m *memoryAllocated) Source() aggregate.ID { return m.Src }
+func (m *memoryAllocated) Dest() aggregate.ID   { return m.Dst }
+
+func init() {
+	if err := RegisterCommand(&allocateMemory{}); err != nil {
+		panic(err)
+	} else if err = RegisterEvent(&memoryAllocated{}); err != nil {
+		panic(err)
+	}
+}
+
+// roundTrip passes @env through Marshal/Unmarshal
+func roundTrip(t *testing.T, env *Envelope) *Envelope {
+	b, err := Marshal(env)
+	if err != nil {
+		t.Fatalf("failed to marshal %s envelope: %s", env.Type, err)
+	}
+	res, err := Unmarshal(b)
+	if err != nil {
+		t.Fatalf("failed to unmarshal %s envelope: %s", env.Type, err)
+	}
+	return res
+}
+
+func TestCommandRoundTrip(t *testing.T) {
+	src := aggregate.ID{Node: "10.0.0.1", Type: aggregate.ResourceType_CPU}
+	dst := aggregate.ID{Node: "10.0.0.2", Type: aggregate.ResourceType_MEMORY, ID: "bank3"}
+
+	for _, data := range []interface{}{&allocateMemory{Bank: "bank3", Bytes: 1 << 30}, "reset"} {
+		cmd, err := aggregate.NewCommand(src, dst, data)
+		if err != nil {
+			t.Fatalf("failed to create command: %s", err)
+		}
+		cmd.WithMetadata(aggregate.Metadata{"tenant": "acme"})
+
+		env, err := EncodeCommand(cmd)
+		if err != nil {
+			t.Fatalf("failed to encode %s: %s", cmd, err)
+		} else if env.Type != cmd.Type() || env.Version != Version {
+			t.Fatalf("invalid envelope header %q v%d", env.Type, env.Version)
+		}
+
+		res, err := DecodeCommand(roundTrip(t, env))
+		if err != nil {
+			t.Fatalf("failed to decode %s: %s", cmd, err)
+		} else if res.ID() != cmd.ID() {
+			t.Fatalf("command ID garbled: expected %s, got %s", cmd.ID(), res.ID())
+		} else if res.Source() != src || res.Dest() != dst {
+			t.Fatalf("addresses garbled: %s => %s", res.Source(), res.Dest())
+		} else if !reflect.DeepEqual(res.Data(), data) {
+			t.Fatalf("data garbled: expected %#v, got %#v", data, res.Data())
+		} else if !reflect.DeepEqual(res.Metadata(), cmd.Metadata()) {
+			t.Fatalf("metadata garbled: expected %v, got %v", cmd.Metadata(), res.Metadata())
+		}
+	}
+
+	// Unregistered types can be encoded, but not decoded
+	type unregistered struct{ Foo string }
+
+	cmd, _ := aggregate.NewCommand(src, dst, unregistered{"bar"})
+	if env, err := EncodeCommand(cmd); err != nil {
+		t.Fatalf("failed to encode unregistered command: %s", err)
+	} else if _, err = DecodeCommand(env); fault.CodeOf(err) != fault.Rejected {
+		t.Fatalf("expected decoding of unregistered command to be rejected, got %v", err)
+	}
+
+	// Conflicting registration
+	type allocateMemory struct{ Foo string }
+	if err := RegisterCommand(allocateMemory{}); fault.CodeOf(err) != fault.Conflict {
+		t.Fatalf("expected conflict registering conflicting type name, got %v", err)
+	}
+}
+
+func TestEventRoundTrip(t *testing.T) {
+	src := aggregate.ID{Node: "10.0.0.2", Type: aggregate.ResourceType_MEMORY, ID: "bank3"}
+	dst := aggregate.ID{Node: "10.0.0.1", Type: aggregate.ResourceType_CPU}
+
+	for _, e := range []event.Event{
+		&memoryAllocated{Bank: "bank3", Src: src, Dst: dst},
+		&event.ServiceReady{Aggregate: src},
+		&event.CommandDone{Src: src, Dst: dst, CmdID: "42", Desc: "allocateMemory", Data: &allocateMemory{Bank: "bank3"}, Error: "out of memory"},
+		&event.CommandDone{Src: src, Dst: dst, Desc: "reset", Data: "reset", Status: "done", Attempts: 3},
+		&event.CommandDone{Src: src, Dst: dst, Desc: "reset", Data: "reset", Branches: []*event.CommandDone{
+			{Src: src, Dst: dst, CmdID: "43", Desc: "reset", Data: "reset", Status: "done"},
+		}},
+		&event.CommandDone{Src: src, Dst: dst, Desc: "count", Data: "count", Status: "42", Value: 42},
+		&event.CommandDone{Src: src, Dst: dst, Desc: "reset", Data: "reset", Meta: event.Meta{Md: aggregate.Metadata{"user": "alice"}}},
+		&event.CommandDone{Src: src, Dst: dst, Desc: "reset", Data: "reset", Error: "busy",
+			Fault: fault.New("unavailable", "busy").WithDetail("retry", "1s")},
+	} {
+		env, err := EncodeEvent(e)
+		if err != nil {
+			t.Fatalf("failed to encode %v: %s", e, err)
+		}
+
+		res, err := DecodeEvent(roundTrip(t, env))
+		if err != nil {
+			t.Fatalf("failed to decode %v: %s", e, err)
+		} else if !reflect.DeepEqual(res, e) {
+			t.Fatalf("event garbled: expected %#v, got %#v", e, res)
+		}
+	}
+
+	// Kind mismatch
+	env, _ := EncodeEvent(&event.ServiceReady{Aggregate: src})
+	if _, err := DecodeCommand(env); err == nil {
+		t.Fatalf("expected error decoding event envelope as command, but got nil")
+	}
+
+	// Unsupported version
+	if _, err := Unmarshal([]byte(`{"v":99,"kind":"event","type":"ServiceReady"}`)); err == nil {
+		t.Fatalf("expected error unmarshalling unsupported version, but got nil")
+	}
+
+	// Results of unregistered types fall back to their string form.
+	type usage struct{ Bytes int }
+	env, _ = EncodeEvent(&event.CommandDone{Src: src, Dst: dst, Desc: "usage", Data: "usage", Status: "{42}", Value: usage{42}})
+	if e, err := DecodeEvent(roundTrip(t, env)); err != nil {
+		t.Fatalf("failed to decode CommandDone: %s", err)
+	} else if res := e.(*event.CommandDone).Result(); res.Result != "{42}" {
+		t.Fatalf("unexpected result %#v", res.Result)
+	}
+
+	// Completions of unregistered command types are decoded without their command data.
+	type unregistered struct{ Bytes int }
+	env, _ = EncodeEvent(&event.CommandDone{Src: src, Dst: dst, CmdID: "44", Desc: "unregistered", Data: unregistered{1}, Error: "failed"})
+	if e, err := DecodeEvent(roundTrip(t, env)); err != nil {
+		t.Fatalf("failed to decode CommandDone: %s", err)
+	} else if cd := e.(*event.CommandDone); cd.CmdID != "44" || cd.Error != "failed" || cd.Data != nil {
+		t.Fatalf("unexpected CommandDone %#v", cd)
+	}
+}
@@ -0,0 +1,87 @@
+package codec
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// EncodeCommand returns the envelope representing @cmd.
+func EncodeCommand(cmd *aggregate.Command) (*Envelope, error) {
+	if cmd == nil {
+		return nil, fault.New(fault.Rejected, "attempt to encode a nil command")
+	}
+
+	payload, err := json.Marshal(cmd.Data())
+	if err != nil {
+		return nil, fault.Wrapf(err, fault.Rejected, "failed to encode %s", cmd.Type())
+	}
+
+	return &Envelope{
+		Version:  Version,
+		Kind:     KindCommand,
+		Type:     cmd.Type(),
+		ID:       cmd.ID(),
+		Src:      cmd.Source(),
+		Dst:      cmd.Dest(),
+		Payload:  payload,
+		Metadata: cmd.Metadata(),
+	}, nil
+}
+
+// DecodeCommand restores the Command represented by @env.
+func DecodeCommand(env *Envelope) (*aggregate.Command, error) {
+	if env.Kind != KindCommand {
+		return nil, fault.New(fault.Rejected, "attempt to decode %s envelope %q as command", env.Kind, env.Type)
+	}
+
+	data, err := decodeCommandData(env.Type, env.Payload)
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := aggregate.NewCommandWithID(env.ID, env.Src, env.Dst, data)
+	if err != nil {
+		return nil, err
+	}
+	return cmd.WithMetadata(env.Metadata), nil
+}
+
+// decodeCommandData decodes the command payload @payload of command type @typ.
+func decodeCommandData(typ string, payload json.RawMessage) (interface{}, error) {
+	if t, ok := lookup(registry.commands, typ); ok {
+		return newValue(t, payload)
+	}
+
+	// String commands are self-describing: the payload equals the type.
+	var s string
+	if err := json.Unmarshal(payload, &s); err == nil && s == typ {
+		return s, nil
+	}
+	return nil, fault.New(fault.Rejected, "unable to decode unregistered command type %q", typ)
+}
+
+// commandData is the self-describing encoding of a command payload embedded in an event.
+type commandData struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// encodeCommandData returns the encoding of the command payload @data (may be nil).
+func encodeCommandData(data interface{}) (*commandData, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	cmd, err := aggregate.NewLocalCommand(aggregate.ID{}, data)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, fault.Wrapf(err, fault.Rejected, "failed to encode %s", reflect.TypeOf(data))
+	}
+	return &commandData{Type: cmd.Type(), Payload: payload}, nil
+}
@@ -0,0 +1,153 @@
+package codec
+
+import (
+	"encoding/json"
+
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// EncodeEvent returns the envelope representing @e.
+func EncodeEvent(e event.Event) (*Envelope, error) {
+	var payload json.RawMessage
+	var err error
+
+	if e == nil {
+		return nil, fault.New(fault.Rejected, "attempt to encode a nil event")
+	}
+
+	if cd, ok := e.(*event.CommandDone); ok {
+		payload, err = encodeCommandDone(cd)
+	} else {
+		payload, err = json.Marshal(e)
+	}
+	if err != nil {
+		return nil, fault.Wrapf(err, fault.Rejected, "failed to encode %s event", EventType(e))
+	}
+
+	return &Envelope{
+		Version:  Version,
+		Kind:     KindEvent,
+		Type:     EventType(e),
+		Src:      e.Source(),
+		Dst:      e.Dest(),
+		Payload:  payload,
+		Metadata: event.MetadataOf(e),
+	}, nil
+}
+
+// DecodeEvent restores the Event represented by @env.
+func DecodeEvent(env *Envelope) (event.Event, error) {
+	if env.Kind != KindEvent {
+		return nil, fault.New(fault.Rejected, "attempt to decode %s envelope %q as event", env.Kind, env.Type)
+	}
+
+	t, ok := lookup(registry.events, env.Type)
+	if !ok {
+		return nil, fault.New(fault.Rejected, "unable to decode unregistered event type %q", env.Type)
+	} else if env.Type == EventType(&event.CommandDone{}) {
+		return decodeCommandDone(env)
+	}
+
+	v, err := newValue(t, env.Payload)
+	if err != nil {
+		return nil, err
+	} else if e, ok := v.(event.Event); ok {
+		if c, ok := e.(event.MetadataCarrier); ok && len(env.Metadata) > 0 {
+			c.SetMetadata(env.Metadata)
+		}
+		return e, nil
+	}
+	return nil, fault.New(fault.Rejected, "registered type %s of %q does not implement event.Event", t, env.Type)
+}
+
+// commandDone is the payload of a CommandDone envelope.
+// CommandDone.Data carries the (arbitrary) data of the completed command, which
+// is encoded in self-describing form, as is the result value. Both are restored only if
+// their type is registered (see RegisterCommand, RegisterResult). Src/Dst are taken from the envelope.
+type commandDone struct {
+	CmdID  string       `json:"cmd_id"`
+	Desc   string       `json:"desc,omitempty"`
+	Data   *commandData `json:"data,omitempty"`
+	Status string       `json:"status,omitempty"`
+	Error  string       `json:"error,omitempty"`
+
+	Result *resultData  `json:"result,omitempty"`
+	Fault  *fault.Error `json:"fault,omitempty"`
+
+	Attempts int `json:"attempts,omitempty"`
+
+	Branches []*Envelope `json:"branches,omitempty"`
+}
+
+func encodeCommandDone(cd *event.CommandDone) (json.RawMessage, error) {
+	var branches []*Envelope
+
+	data, err := encodeCommandData(cd.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := encodeResult(cd.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range cd.Branches {
+		env, err := EncodeEvent(b)
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, env)
+	}
+
+	return json.Marshal(commandDone{
+		CmdID:    cd.CmdID,
+		Desc:     cd.Desc,
+		Data:     data,
+		Status:   cd.Status,
+		Error:    cd.Error,
+		Result:   result,
+		Fault:    cd.Fault,
+		Attempts: cd.Attempts,
+		Branches: branches,
+	})
+}
+
+func decodeCommandDone(env *Envelope) (event.Event, error) {
+	var payload commandDone
+	var cd = &event.CommandDone{Src: env.Src, Dst: env.Dst, Meta: event.Meta{Md: env.Metadata}}
+
+	if err := json.Unmarshal(env.Payload, &payload); err != nil {
+		return nil, fault.Wrapf(err, fault.Rejected, "failed to decode CommandDone payload")
+	}
+	cd.CmdID, cd.Desc, cd.Status, cd.Error = payload.CmdID, payload.Desc, payload.Status, payload.Error
+	cd.Fault, cd.Attempts = payload.Fault, payload.Attempts
+
+	value, err := decodeResult(payload.Result)
+	if err != nil {
+		return nil, fault.Wrapf(err, fault.Rejected, "CommandDone(%s)", cd.Desc)
+	}
+	cd.Value = value
+
+	// Without the command data, the completion still serves to release the issuer.
+	if payload.Data != nil {
+		if data, err := decodeCommandData(payload.Data.Type, payload.Data.Payload); err != nil {
+			logger.Warningf("CommandDone(%s) of command %s: omitting command data: %s", cd.Desc, cd.CmdID, err)
+		} else {
+			cd.Data = data
+		}
+	}
+
+	for _, env := range payload.Branches {
+		b, err := DecodeEvent(env)
+		if err != nil {
+			return nil, fault.Wrapf(err, fault.Rejected, "CommandDone(%s)", cd.Desc)
+		} else if bcd, ok := b.(*event.CommandDone); !ok {
+			return nil, fault.New(fault.Rejected, "CommandDone(%s): unexpected branch %s", cd.Desc, env.Type)
+		} else {
+			cd.Branches = append(cd.Branches, bcd)
+		}
+	}
+	return cd, nil
+}
@@ -0,0 +1,59 @@
+package codec
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// RegisterResult registers the type of the command result @v, so that results of this type
+// can be restored from a CommandDone envelope. Predeclared types, such as string and int, are pre-registered.
+func RegisterResult(v interface{}) error {
+	if v == nil {
+		return fault.New(fault.Rejected, "attempt to register a nil result")
+	}
+	return register(registry.results, resultType(v), reflect.TypeOf(v))
+}
+
+// resultType returns the type name of the command result @v.
+func resultType(v interface{}) string {
+	var t = reflect.TypeOf(v)
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Name() == "" {
+		return t.String()
+	}
+	return t.Name()
+}
+
+// resultData is the self-describing encoding of a command result.
+type resultData struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// encodeResult returns the encoding of the command result @v (may be nil).
+func encodeResult(v interface{}) (*resultData, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, fault.Wrapf(err, fault.Rejected, "failed to encode %s result", reflect.TypeOf(v))
+	}
+	return &resultData{Type: resultType(v), Payload: payload}, nil
+}
+
+// decodeResult restores the command result encoded as @r. Results of unregistered types are restored as nil.
+func decodeResult(r *resultData) (interface{}, error) {
+	if r == nil {
+		return nil, nil
+	} else if t, ok := lookup(registry.results, r.Type); ok {
+		return newValue(t, r.Payload)
+	}
+	return nil, nil
+}
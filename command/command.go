@@ -4,6 +4,8 @@ package command
 import (
 	"context"
 	"fmt"
+
+	"github.com/grrtrr/magicbus/fault"
 )
 
 // Command carries a struct or string in Data() and is associated with a Job().
@@ -19,6 +21,8 @@ type Command interface {
 type Result struct {
 	Result interface{} // Return value(s), may be nil
 	Err    error       // Non-nil value if the command failed
+
+	Branches []Result // Results of the follow-up commands (next step, or fan-out branches and join), in order
 }
 
 func (r Result) String() string {
@@ -34,3 +38,31 @@ func (r Result) String() string {
 	}
 	return fmt.Sprintf("result = %v", r.Result)
 }
+
+// BranchErr returns the first error among the follow-up commands of @r, including their own follow-ups
+// (nil if all of them succeeded). Unlike @r.Err, which is the outcome of the command itself.
+func (r Result) BranchErr() error {
+	for _, b := range r.Branches {
+		if b.Err != nil {
+			return b.Err
+		} else if err := b.BranchErr(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResultAs returns the result value of @r as type T, or the error of @r if the command failed.
+// A nil result value yields the zero value of T.
+func ResultAs[T any](r Result) (T, error) {
+	var zero T
+
+	if r.Err != nil {
+		return zero, r.Err
+	} else if r.Result == nil {
+		return zero, nil
+	} else if v, ok := r.Result.(T); ok {
+		return v, nil
+	}
+	return zero, fault.New(fault.Internal, "result %v is of type %T, not %T", r.Result, r.Result, zero)
+}
@@ -0,0 +1,133 @@
+package magicbus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+)
+
+// Default number of dead letters retained for inspection
+const defaultDeadLetterCapacity = 1000
+
+// DeadLetter records a command or event that could not be delivered.
+type DeadLetter struct {
+	Time    time.Time          // When delivery failed
+	Command *aggregate.Command // The command that could not be routed (nil if Event is set)
+	Event   event.Event        // The event that had no consumer (nil if Command is set)
+	Reason  string             // Why delivery failed
+}
+
+func (d DeadLetter) String() string {
+	if d.Command != nil {
+		return fmt.Sprintf("dead letter: command %s => %s (%s)", d.Command, d.Command.Dest(), d.Reason)
+	}
+	return fmt.Sprintf("dead letter: event %v => %s (%s)", d.Event, d.Event.Dest(), d.Reason)
+}
+
+// DeadLetterHandler is notified of each new DeadLetter.
+type DeadLetterHandler func(DeadLetter)
+
+// deadLetters retains the most recent dead letters in a bounded buffer, and notifies subscribers.
+type deadLetters struct {
+	sync.Mutex
+
+	// Maximum number of retained letters, oldest letters are discarded first
+	capacity int
+
+	// Retained letters, in order of arrival
+	letters []DeadLetter
+
+	// Subscribers (map { SubscriptionID -> DeadLetterHandler })
+	handlers map[string]DeadLetterHandler
+}
+
+func newDeadLetters(capacity int) *deadLetters {
+	return &deadLetters{capacity: capacity, handlers: map[string]DeadLetterHandler{}}
+}
+
+// add records @d and notifies all subscribers.
+func (dl *deadLetters) add(d DeadLetter) {
+	dl.Lock()
+	defer dl.Unlock()
+
+	logger.Warningf("magicbus: %s", d)
+
+	if dl.capacity > 0 {
+		if len(dl.letters) >= dl.capacity {
+			dl.letters = append(dl.letters[:0], dl.letters[len(dl.letters)-dl.capacity+1:]...)
+		}
+		dl.letters = append(dl.letters, d)
+	}
+
+	for _, handler := range dl.handlers {
+		go handler(d)
+	}
+}
+
+// list returns a copy of the retained letters, oldest first.
+func (dl *deadLetters) list() []DeadLetter {
+	dl.Lock()
+	defer dl.Unlock()
+
+	return append([]DeadLetter(nil), dl.letters...)
+}
+
+// DeadLetters returns the most recent dead letters of the local bus, oldest first.
+func DeadLetters() []DeadLetter {
+	return localBus.DeadLetters()
+}
+
+// SubscribeDeadLetters registers @hdlr to be notified of each new dead letter on the local bus.
+func SubscribeDeadLetters(hdlr DeadLetterHandler) SubscriptionID {
+	return localBus.SubscribeDeadLetters(hdlr)
+}
+
+// UnsubscribeDeadLetters removes dead-letter subscription @id from the local bus.
+func UnsubscribeDeadLetters(id SubscriptionID) {
+	localBus.UnsubscribeDeadLetters(id)
+}
+
+// DeadLetters returns the most recent dead letters of @m, oldest first.
+func (m *MagicBus) DeadLetters() []DeadLetter {
+	return m.deadLetters.list()
+}
+
+// SubscribeDeadLetters registers @hdlr to be notified of each new dead letter on @m.
+func (m *MagicBus) SubscribeDeadLetters(hdlr DeadLetterHandler) SubscriptionID {
+	var id = NewSubscriptionID()
+
+	m.deadLetters.Lock()
+	defer m.deadLetters.Unlock()
+
+	m.deadLetters.handlers[id.String()] = hdlr
+	return id
+}
+
+// UnsubscribeDeadLetters removes dead-letter subscription @id from @m.
+func (m *MagicBus) UnsubscribeDeadLetters(id SubscriptionID) {
+	m.deadLetters.Lock()
+	defer m.deadLetters.Unlock()
+
+	delete(m.deadLetters.handlers, id.String())
+}
+
+// deadLetterCommand records @cmd as undeliverable, and reports the failure to the issuer of @cmd.
+func (m *MagicBus) deadLetterCommand(cmd *aggregate.Command, err error) {
+	m.deadLetters.add(DeadLetter{Time: time.Now(), Command: cmd, Reason: err.Error()})
+
+	// A command may be undeliverable between retries, after it has already been handled.
+	cd := event.NewCmdDone(cmd.Dest(), cmd, nil, err).(*event.CommandDone)
+	cd.Attempts = m.forgetAttempts(cmd)
+
+	if err := m.Publish(cd); err != nil {
+		logger.Errorf("magicbus: failed to notify %s of undeliverable %s: %s", cmd.Source(), cmd, err)
+	}
+}
+
+// deadLetterEvent records @e as not having been consumed by anyone.
+func (m *MagicBus) deadLetterEvent(e event.Event, reason string) {
+	m.deadLetters.add(DeadLetter{Time: time.Now(), Event: e, Reason: reason})
+}
@@ -0,0 +1,75 @@
+package magicbus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+)
+
+func TestDeadLetters(t *testing.T) {
+	var m = NewMagicBus(context.Background(), WithDeadLetterCapacity(2))
+	defer m.Shutdown()
+
+	var alerts = make(chan DeadLetter, 10)
+	m.SubscribeDeadLetters(func(d DeadLetter) { alerts <- d })
+
+	awaitAlert := func() DeadLetter {
+		select {
+		case d := <-alerts:
+			return d
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for dead letter")
+		}
+		panic("not reached")
+	}
+
+	// 1. Event without consumer
+	nowhere := aggregate.NewID(aggregate.ResourceType_MEMORY, "nowhere")
+	te := mkTestEvent(nowhere, nowhere, "nobody listens")
+	if err := m.Publish(te); err != nil {
+		t.Fatalf("failed to publish %s: %s", te, err)
+	} else if d := awaitAlert(); d.Event != te {
+		t.Fatalf("unexpected dead letter %s", d)
+	}
+
+	// 2. Unroutable command: the issuer receives a failed CommandDone
+	var done = make(chan *event.CommandDone, 1)
+
+	id, err := m.Observer(func(e event.Event) {
+		if cd, ok := e.(*event.CommandDone); ok {
+			done <- cd
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+	defer m.Unsubscribe(id)
+
+	for i := 0; i < 2; i++ {
+		cmd, _ := aggregate.NewCommand(aggregate.NewID(aggregate.ResourceType_CPU, ""), nowhere, cmd1)
+		if err := m.Submit(cmd); err != nil {
+			t.Fatalf("failed to submit %s: %s", cmd, err)
+		} else if d := awaitAlert(); d.Command != cmd {
+			t.Fatalf("unexpected dead letter %s", d)
+		}
+
+		select {
+		case cd := <-done:
+			if cd.CmdID != cmd.ID() || cd.Result().Err == nil {
+				t.Fatalf("expected failed CommandDone for %s, got %s", cmd, cd)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for CommandDone of %s", cmd)
+		}
+	}
+
+	// 3. The buffer is bounded: only the most recent letters are retained.
+	if letters := m.DeadLetters(); len(letters) != 2 {
+		t.Fatalf("expected 2 retained dead letters, got %d", len(letters))
+	} else if letters[0].Command == nil || letters[1].Command == nil {
+		t.Fatalf("oldest dead letter was not discarded: %v", letters)
+	}
+}
@@ -6,45 +6,79 @@ import (
 
 	"github.com/grrtrr/magicbus/aggregate"
 	"github.com/grrtrr/magicbus/command"
+	"github.com/grrtrr/magicbus/fault"
 )
 
 // CommandDone is the '<cmd>Done' event published whenever a command completes.
 type CommandDone struct {
-	Src aggregate.ID // Aggregate reporting this event, the status of a command just run
-	Dst aggregate.ID // Intended destination Aggregate (issuer of the command to be run)
+	Src   aggregate.ID // Aggregate reporting this event, the status of a command just run
+	Dst   aggregate.ID // Intended destination Aggregate (issuer of the command to be run)
+	CmdID string       // ID of the completed command
 
 	Desc   string      // Descriptive text (used for logging)
 	Data   interface{} // The command data embedded in the original command
 	Status string      // Result: success status as string
 	Error  string      // Result: stringified error (empty means no error)
+
+	Value interface{}  // Result: original result value (nil if none, or if it could not be decoded)
+	Fault *fault.Error // Result: structured error (nil means no error)
+
+	Attempts int // Number of times the command was handled (> 1 if retried, 0 if never handled)
+
+	Branches []*CommandDone // Completion of the follow-up commands (next step, or fan-out branches and join)
+
+	Meta // Metadata of the completed command, which is also its cause
 }
 
 // NewCmdDone is a convenience wrapper that fills in an event from @a and @cmd
 func NewCmdDone(src aggregate.ID, cmd *aggregate.Command, result interface{}, err error) Event {
 	var cd = &CommandDone{
-		Src:  src,
-		Dst:  cmd.Source(),
-		Data: cmd.Data(),
-		Desc: cmd.Type(),
+		Src:   src,
+		Dst:   cmd.Source(),
+		CmdID: cmd.ID(),
+		Data:  cmd.Data(),
+		Desc:  cmd.Type(),
+		Meta:  Meta{Md: cmd.CausedMetadata()},
 	}
 
 	if result != nil {
-		cd.Status = fmt.Sprint(result) // FIXME: stringification
+		cd.Status, cd.Value = fmt.Sprint(result), result
 	}
+	cd.Fail(err)
+	return cd
+}
 
+// Fail sets the result of @c to @err, unless @err is nil.
+func (c *CommandDone) Fail(err error) {
 	if err != nil {
-		cd.Error = err.Error()
+		c.Error, c.Fault = err.Error(), fault.From(err)
 	}
-	return cd
 }
 
 func (c *CommandDone) Source() aggregate.ID { return c.Src }
 func (c *CommandDone) Dest() aggregate.ID   { return c.Dst }
+
+// Result returns the original result value and error of @c, falling back to their string form
+// if @c was received from a remote bus which does not know the type of the result.
 func (c *CommandDone) Result() command.Result {
-	if c.Error != "" {
-		return command.Result{Result: c.Status, Err: errors.New(c.Error)}
+	var res command.Result
+
+	if c.Value != nil {
+		res.Result = c.Value
+	} else if c.Status != "" {
+		res.Result = c.Status
+	}
+
+	for _, b := range c.Branches {
+		res.Branches = append(res.Branches, b.Result())
+	}
+
+	if c.Fault != nil {
+		res.Err = c.Fault
+	} else if c.Error != "" {
+		res.Err = errors.New(c.Error)
 	}
-	return command.Result{Result: c.Status}
+	return res
 }
 
 func (c CommandDone) String() string {
@@ -0,0 +1,41 @@
+package event
+
+import "github.com/grrtrr/magicbus/aggregate"
+
+// MetadataCarrier is implemented by events which carry metadata (see aggregate.Metadata),
+// including the correlation and causation IDs which link them to the command that caused them.
+// Events become MetadataCarriers by embedding Meta. This is opt-in: other events carry no
+// metadata, hence they are not stamped by the bus, and not part of any recorded causal history.
+type MetadataCarrier interface {
+	Metadata() aggregate.Metadata
+	SetMetadata(aggregate.Metadata)
+}
+
+// Meta implements MetadataCarrier, for embedding in events.
+type Meta struct {
+	Md aggregate.Metadata `json:"-"` // Encoded as part of the envelope rather than of the payload
+}
+
+func (m *Meta) Metadata() aggregate.Metadata      { return m.Md }
+func (m *Meta) SetMetadata(md aggregate.Metadata) { m.Md = md }
+
+// MetadataOf returns the metadata of @e (nil if @e is not a MetadataCarrier).
+func MetadataOf(e Event) aggregate.Metadata {
+	if c, ok := e.(MetadataCarrier); ok {
+		return c.Metadata()
+	}
+	return nil
+}
+
+// Propagate completes the metadata of @e with @md, e.g. the metadata of the command being handled,
+// and returns @e. It has no effect unless @e is a MetadataCarrier.
+// The bus stamps events automatically which an Aggregate publishes while handling a command, hence
+// Propagate is only needed for events published otherwise, e.g. from a goroutine after the fact:
+//
+//	magicbus.Publish(event.Propagate(&memoryAllocated{...}, cmd.CausedMetadata()))
+func Propagate(e Event, md aggregate.Metadata) Event {
+	if c, ok := e.(MetadataCarrier); ok && len(md) > 0 {
+		c.SetMetadata(c.Metadata().Inherit(md))
+	}
+	return e
+}
@@ -0,0 +1,27 @@
+package event
+
+import "github.com/grrtrr/magicbus/aggregate"
+
+// SourcedAggregate is an Aggregate whose state is derived entirely from its domain events:
+// each command results in a list of events, which are applied to the state and then published.
+// The state can thus be rebuilt at any time by replaying the history of events.
+type SourcedAggregate interface {
+	// Returns the cluster-unique ID of this Aggregate
+	AggregateID() aggregate.ID
+
+	// HandleCommand validates @cmd against the current state, and returns the resulting events.
+	// It must not modify the state: this is done by ApplyEvent, once for each of @events.
+	// @events: the events resulting from @cmd, may be empty
+	// @err:    rejects @cmd - no events are applied in this case
+	HandleCommand(cmd *aggregate.Command) (events []Event, err error)
+
+	// ApplyEvent updates the state according to @e.
+	// It can not fail, since @e represents something that has already happened.
+	ApplyEvent(e Event)
+}
+
+// SourcedFactory creates the event-sourced Aggregate identified by @id, in its initial state.
+type SourcedFactory func(id aggregate.ID) (SourcedAggregate, error)
+
+// History returns the events of Aggregate @id, in the order they were applied.
+type History func(id aggregate.ID) ([]Event, error)
@@ -0,0 +1,138 @@
+package magicbus
+
+import (
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// RegisterEventSourced registers the event-sourced Aggregate @a to handle commands on the local bus.
+// Before registration, the state of @a is rebuilt by replaying its history (see WithHistory).
+// @ready: whether the aggregate is ready to process commands right away
+// @opts:  optional settings, e.g. supervision
+func RegisterEventSourced(a event.SourcedAggregate, ready bool, opts ...RegisterOption) {
+	if err := localBus.RegisterEventSourced(a, ready, opts...); err != nil {
+		logger.Fatalf("%s: registration failed: %s", a.AggregateID(), err)
+	}
+}
+
+// RegisterEventSourced rehydrates @a from its history, and registers it to handle commands on @m.
+// To survive a Restart directive or passivation, @opts have to include WithSourcedFactory.
+func (m *MagicBus) RegisterEventSourced(a event.SourcedAggregate, ready bool, opts ...RegisterOption) error {
+	if a == nil {
+		return fault.New(fault.Rejected, "attempt to register a nil Aggregate")
+	}
+
+	s, err := m.newSourcedAggregate(a)
+	if err != nil {
+		return err
+	}
+	return m.Register(s, ready, opts...)
+}
+
+// RegisterSourcedFactory lets @f create the specific event-sourced Aggregates of type @rt on the local bus.
+// @opts: registration settings of the created Aggregates
+func RegisterSourcedFactory(rt aggregate.ResourceType, f event.SourcedFactory, opts ...RegisterOption) {
+	if err := localBus.RegisterSourcedFactory(rt, f, opts...); err != nil {
+		logger.Fatalf("%s: factory registration failed: %s", rt, err)
+	}
+}
+
+// RegisterSourcedFactory is RegisterFactory for event-sourced Aggregates: the Aggregates created
+// by @f are rehydrated from their history, also when re-created on restart or reactivation.
+func (m *MagicBus) RegisterSourcedFactory(rt aggregate.ResourceType, f event.SourcedFactory, opts ...RegisterOption) error {
+	if f == nil {
+		return fault.New(fault.Rejected, "attempt to register a nil factory for %s", rt)
+	}
+	return m.registerFactory(rt, &factory{}, append([]RegisterOption{WithSourcedFactory(f)}, opts...))
+}
+
+// sourcedAggregate adapts an event.SourcedAggregate to the aggregate.Aggregate interface.
+type sourcedAggregate struct {
+	event.SourcedAggregate
+
+	// Bus to publish the resulting events on
+	bus *MagicBus
+
+	// Number of events applied so far
+	version uint64
+}
+
+// newSourcedAggregate wraps @a, after replaying its history.
+func (m *MagicBus) newSourcedAggregate(a event.SourcedAggregate) (*sourcedAggregate, error) {
+	var s = &sourcedAggregate{SourcedAggregate: a, bus: m}
+
+	if m.history != nil {
+		events, err := m.history(a.AggregateID())
+		if err != nil {
+			return nil, fault.Wrapf(err, fault.Unavailable, "%s: failed to load history", a.AggregateID())
+		}
+		s.apply(events)
+		logger.Debugf("magicbus: %s rehydrated from %d events", a.AggregateID(), len(events))
+	}
+	return s, nil
+}
+
+// recreate creates a fresh instance of the event-sourced Aggregate @id via @f, and rehydrates it.
+func (m *MagicBus) recreate(id aggregate.ID, f event.SourcedFactory) (aggregate.Aggregate, error) {
+	a, err := f(id)
+	if err != nil {
+		return nil, fault.Wrapf(err, fault.Internal, "%s: failed to create aggregate", id)
+	} else if isNil(a) {
+		return nil, fault.New(fault.Internal, "%s: factory returned a nil aggregate", id)
+	} else if a.AggregateID() != id {
+		return nil, fault.New(fault.Internal, "%s: factory returned mismatching aggregate", id)
+	}
+	return m.newSourcedAggregate(a)
+}
+
+// HandleCommand stores and applies the events resulting from @cmd, and publishes them afterwards.
+// The events inherit the metadata of @cmd, which is recorded as their cause.
+// If the stream of the Aggregate has been appended to in the meantime, the command fails
+// with eventstore.ErrConflict.
+func (s *sourcedAggregate) HandleCommand(cmd *aggregate.Command) (*aggregate.Command, interface{}, error) {
+	events, err := s.SourcedAggregate.HandleCommand(cmd)
+	if err != nil {
+		return nil, nil, err
+	} else if len(events) == 0 {
+		return nil, nil, nil
+	}
+
+	for _, e := range events {
+		event.Propagate(e, cmd.CausedMetadata())
+	}
+
+	if s.bus.store != nil {
+		if _, err := s.bus.store.Append(s.AggregateID(), int64(s.version), events...); err != nil {
+			return nil, nil, fault.Wrapf(err, fault.Unavailable, "%s: failed to store events", s.AggregateID())
+		}
+	}
+	s.apply(events)
+
+	for _, e := range events {
+		var publish = s.bus.Publish
+
+		if s.bus.store != nil {
+			publish = s.bus.publishStored
+		}
+		if err := publish(e); err != nil {
+			logger.Errorf("%s: failed to publish %v: %s", s.AggregateID(), e, err)
+		}
+	}
+	return nil, nil, nil
+}
+
+// HandleEvent passes @e on, if the underlying Aggregate is an event.EventHandler.
+func (s *sourcedAggregate) HandleEvent(e event.Event) {
+	if eh, ok := s.SourcedAggregate.(event.EventHandler); ok {
+		eh.HandleEvent(e)
+	}
+}
+
+// apply applies @events in order.
+func (s *sourcedAggregate) apply(events []event.Event) {
+	for _, e := range events {
+		s.ApplyEvent(e)
+		s.version++
+	}
+}
@@ -0,0 +1,261 @@
+package magicbus
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/codec"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/eventstore"
+	"github.com/pkg/errors"
+)
+
+func TestEventSourcedAggregate(t *testing.T) {
+	var id = aggregate.NewID(aggregate.ResourceType_MEMORY, "bank3")
+	var history = []event.Event{&allocated{Src: id, Bytes: 100}, &allocated{Src: id, Bytes: 20}}
+
+	m := NewMagicBus(context.Background(), WithHistory(func(hid aggregate.ID) ([]event.Event, error) {
+		if hid != id {
+			return nil, errors.Errorf("unexpected history request for %s", hid)
+		}
+		return history, nil
+	}))
+	defer m.Shutdown()
+
+	var events = make(chan event.Event, 10)
+	if _, err := m.Observer(func(e event.Event) { events <- e }); err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+
+	a := &memoryBank{id: id}
+	if err := m.RegisterEventSourced(a, true); err != nil {
+		t.Fatalf("failed to register %s: %s", id, err)
+	}
+
+	// The state has been rebuilt from the history.
+	if bytes := a.allocated; bytes != 120 {
+		t.Fatalf("expected 120 bytes allocated after rehydration, got %d", bytes)
+	}
+
+	// A command results in an applied and published event, followed by CommandDone.
+	cmd, _ := aggregate.NewLocalCommand(id, allocate{Bytes: 3})
+	if err := m.Submit(cmd); err != nil {
+		t.Fatalf("failed to submit %s: %s", cmd, err)
+	}
+
+	for _, expected := range []string{"allocated", "CommandDone"} {
+		select {
+		case e := <-events:
+			if _, ok := e.(*allocated); ok && expected != "allocated" {
+				t.Fatalf("unexpected event %v", e)
+			} else if cd, ok := e.(*event.CommandDone); ok && (expected != "CommandDone" || cd.Error != "") {
+				t.Fatalf("unexpected %s", cd)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s event", expected)
+		}
+	}
+
+	// A rejected command does not change the state.
+	cmd, _ = aggregate.NewLocalCommand(id, allocate{Bytes: 1000})
+	m.Submit(cmd)
+
+	select {
+	case e := <-events:
+		if cd, ok := e.(*event.CommandDone); !ok || cd.Error == "" {
+			t.Fatalf("expected failed CommandDone, got %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for CommandDone")
+	}
+
+	// Read the state from within the actor loop of the aggregate.
+	var ag *aggregateActor
+	var bytes uint64
+
+	if err := <-m.Action(func() error { ag = m.aggregates[id]; return nil }); err != nil {
+		t.Fatalf("bus action failed: %s", err)
+	} else if err = <-ag.Action(func() error { bytes = a.allocated; return nil }); err != nil {
+		t.Fatalf("aggregate action failed: %s", err)
+	} else if bytes != 123 {
+		t.Fatalf("expected 123 bytes allocated, got %d", bytes)
+	}
+}
+
+// Command to allocate memory
+type allocate struct {
+	Bytes uint64
+}
+
+// Event: memory has been allocated
+type allocated struct {
+	Src   aggregate.ID
+	Bytes uint64
+	event.Meta
+}
+
+func (a *allocated) Source() aggregate.ID { return a.Src }
+func (a *allocated) Dest() aggregate.ID   { return aggregate.ID{} }
+
+// memoryBank is an event-sourced aggregate with a capacity of 128 bytes.
+type memoryBank struct {
+	id        aggregate.ID
+	allocated uint64
+}
+
+func (b *memoryBank) AggregateID() aggregate.ID { return b.id }
+
+func (b *memoryBank) HandleCommand(cmd *aggregate.Command) ([]event.Event, error) {
+	if alloc, ok := cmd.Data().(allocate); !ok {
+		return nil, errors.Errorf("unsupported command %s", cmd)
+	} else if b.allocated+alloc.Bytes > 128 {
+		return nil, errors.Errorf("unable to allocate %d bytes: out of memory", alloc.Bytes)
+	} else {
+		return []event.Event{&allocated{Src: b.id, Bytes: alloc.Bytes}}, nil
+	}
+}
+
+func (b *memoryBank) ApplyEvent(e event.Event) {
+	if alloc, ok := e.(*allocated); ok {
+		b.allocated += alloc.Bytes
+	}
+}
+
+func init() {
+	if err := codec.RegisterEvent(&allocated{}); err != nil {
+		panic(err)
+	}
+}
+
+func TestEventStore(t *testing.T) {
+	var id = aggregate.NewID(aggregate.ResourceType_MEMORY, "bank4")
+	var other = aggregate.NewID(aggregate.ResourceType_MEMORY, "other")
+
+	dir, err := os.MkdirTemp("", "magicbus")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// run registers a memoryBank on a new bus backed by the store in @dir, and submits @cmds to it.
+	run := func(cmds ...allocate) *memoryBank {
+		store, err := eventstore.OpenFile(filepath.Join(dir, "events"))
+		if err != nil {
+			t.Fatalf("failed to open event store: %s", err)
+		}
+		defer store.Close()
+
+		m := NewMagicBus(context.Background(), WithEventStore(store))
+		defer m.Shutdown()
+
+		a := &memoryBank{id: id}
+		if err := m.RegisterEventSourced(a, true); err != nil {
+			t.Fatalf("failed to register %s: %s", id, err)
+		}
+
+		var observed = make(chan event.Event, 16)
+		if _, err := m.Observer(func(e event.Event) { observed <- e }); err != nil {
+			t.Fatalf("failed to subscribe: %s", err)
+		}
+
+		// publish publishes an event of @src, waiting for observers to see it.
+		publish := func(src aggregate.ID) {
+			if err := m.Publish(&allocated{Src: src, Bytes: 1}); err != nil {
+				t.Fatalf("failed to publish: %s", err)
+			}
+			for {
+				select {
+				case e := <-observed:
+					if alloc, ok := e.(*allocated); ok && alloc.Src == src && alloc.Bytes == 1 {
+						return
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("timed out waiting for published event")
+				}
+			}
+		}
+
+		// Events published by others on behalf of the aggregate do not enter its stream.
+		publish(id)
+
+		for _, data := range cmds {
+			cmd, _ := aggregate.NewLocalCommand(id, data)
+			if res := m.LaunchWait(cmd, time.Second); res.Err != nil {
+				t.Fatalf("%s failed: %s", cmd, res.Err)
+			}
+		}
+
+		// Events published by others are stored in the stream of their source, before observers see them.
+		publish(other)
+		if events, err := eventstore.History(store, other); err != nil || len(events) == 0 {
+			t.Fatalf("published event was not stored: %v, %v", events, err)
+		}
+		return a
+	}
+
+	run(allocate{Bytes: 10}, allocate{Bytes: 20})
+
+	// The state of the aggregate survives the restart of the bus.
+	if a := run(); a.allocated != 30 {
+		t.Fatalf("expected 30 bytes allocated after restart, got %d", a.allocated)
+	}
+}
+
+func TestSourcedFactory(t *testing.T) {
+	var id = aggregate.NewID(aggregate.ResourceType_MEMORY, "bank5")
+	var created = make(chan aggregate.ID, 10)
+
+	dir, err := os.MkdirTemp("", "magicbus")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := eventstore.OpenFile(filepath.Join(dir, "events"))
+	if err != nil {
+		t.Fatalf("failed to open event store: %s", err)
+	}
+	defer store.Close()
+
+	m := NewMagicBus(context.Background(), WithEventStore(store))
+	defer m.Shutdown()
+
+	if err := m.RegisterSourcedFactory(aggregate.ResourceType_MEMORY, func(id aggregate.ID) (event.SourcedAggregate, error) {
+		created <- id
+		return &memoryBank{id: id}, nil
+	}, WithPassivation(20*time.Millisecond)); err != nil {
+		t.Fatalf("failed to register factory: %s", err)
+	}
+
+	alloc := func(bytes uint64) error {
+		cmd, _ := aggregate.NewLocalCommand(id, allocate{Bytes: bytes})
+		return m.LaunchWait(cmd, time.Second).Err
+	}
+
+	// The aggregate is created on its first command.
+	if err := alloc(100); err != nil {
+		t.Fatalf("allocation failed: %s", err)
+	}
+
+	// Wait for the idle aggregate to be passivated.
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, _, err := m.QueueDepth(id); err != nil {
+			break
+		} else if time.Since(start) > time.Second {
+			t.Fatalf("%s was not passivated", id)
+		}
+	}
+
+	// The reactivated aggregate is rehydrated from its history, and continues its stream.
+	if err := alloc(20); err != nil {
+		t.Fatalf("allocation after reactivation failed: %s", err)
+	} else if err = alloc(20); err == nil {
+		t.Fatalf("expected allocation beyond the capacity of %s to fail", id)
+	} else if len(created) != 2 {
+		t.Fatalf("expected the aggregate to be created twice, got %d", len(created))
+	}
+}
@@ -0,0 +1,322 @@
+package eventstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/codec"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// GLOBAL VARIABLES
+var logger = logrus.WithField("module", "eventstore")
+
+// FileStore is a Store backed by a single append-only file.
+//
+// Each record is stored on a line of its own, prefixed by a CRC-32 checksum:
+//
+//	<crc32 (8 hex digits)> <JSON-encoded record>\n
+//
+// The last record of each append is marked as committing it. Appends are synced to disk
+// before returning. On opening, the file is scanned, and an append which was cut short
+// (e.g. by a crash) is cut off as a whole: an incomplete last line, along with the preceding
+// records of the same append which lack the commit mark. Any other invalid record means
+// that the file is corrupted, and fails the opening.
+// Events are stored as codec.Envelope, hence their types have to be registered with
+// the codec package in order to be read back.
+type FileStore struct {
+	sync.Mutex
+
+	// Underlying file, opened for reading and appending
+	file *os.File
+
+	// Size of the file, i.e. the offset of the next record
+	size int64
+
+	// Offsets of the records, indexed by global position - 1
+	offsets []int64
+
+	// Global positions of the records of each stream, indexed by stream version - 1
+	streams map[aggregate.ID][]uint64
+}
+
+// fileRecord is the on-disk representation of a Record.
+type fileRecord struct {
+	Stream   aggregate.ID    `json:"stream"`
+	Version  uint64          `json:"version"`
+	Position uint64          `json:"position"`
+	Time     time.Time       `json:"time"`
+	Event    *codec.Envelope `json:"event"`
+	Commit   bool            `json:"commit,omitempty"` // last record of its append
+}
+
+// OpenFile opens (or creates) the FileStore at @path, recovering from an interrupted append.
+func OpenFile(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{file: f, streams: map[aggregate.ID][]uint64{}}
+	if err = s.recover(); err != nil {
+		f.Close()
+		return nil, fault.Wrapf(err, fault.Internal, "%s", path)
+	}
+	return s, nil
+}
+
+// recover builds the index of @s, truncating an incomplete last append.
+func (s *FileStore) recover() error {
+	var r = bufio.NewReader(s.file)
+	var pending []*fileRecord // records of the current append, until committed
+	var offsets []int64       // offsets of the pending records
+	var offset = s.size       // offset of the next line
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 || len(pending) > 0 {
+				logger.Warningf("%s: discarding incomplete append at offset %d", s.file.Name(), s.size)
+			}
+			break
+		} else if err != nil {
+			return err
+		}
+
+		rec, err := parseLine(line)
+		if err == nil {
+			err = s.verify(rec, pending)
+		}
+		if err != nil {
+			return fault.Wrapf(err, fault.Internal, "corrupted record at offset %d", offset)
+		}
+
+		pending, offsets = append(pending, rec), append(offsets, offset)
+		offset += int64(len(line))
+		if rec.Commit {
+			for i, rec := range pending {
+				s.index(rec, offsets[i])
+			}
+			pending, offsets, s.size = nil, nil, offset
+		}
+	}
+
+	if err := s.file.Truncate(s.size); err != nil {
+		return err
+	}
+	_, err := s.file.Seek(s.size, io.SeekStart)
+	return err
+}
+
+// verify checks that @rec follows the records indexed by @s, and the @pending records of the same append.
+func (s *FileStore) verify(rec *fileRecord, pending []*fileRecord) error {
+	var position = uint64(len(s.offsets) + len(pending) + 1)
+
+	if rec.Position != position {
+		return fault.New(fault.Internal, "expected record #%d, found #%d", position, rec.Position)
+	} else if len(pending) > 0 && rec.Stream != pending[0].Stream {
+		return fault.New(fault.Internal, "%s: append interrupted by %s", pending[0].Stream, rec.Stream)
+	} else if version := uint64(len(s.streams[rec.Stream]) + len(pending) + 1); rec.Version != version {
+		return fault.New(fault.Internal, "%s: expected version %d, found %d", rec.Stream, version, rec.Version)
+	}
+	return nil
+}
+
+// index adds @rec, stored at @offset, to the index of @s.
+func (s *FileStore) index(rec *fileRecord, offset int64) {
+	s.offsets = append(s.offsets, offset)
+	s.streams[rec.Stream] = append(s.streams[rec.Stream], rec.Position)
+}
+
+// Append implements Store.
+func (s *FileStore) Append(stream aggregate.ID, expectedVersion int64, events ...event.Event) (uint64, error) {
+	var buf bytes.Buffer
+	var records []*fileRecord
+	var offsets []int64
+
+	s.Lock()
+	defer s.Unlock()
+
+	version := uint64(len(s.streams[stream]))
+	if expectedVersion != AnyVersion && uint64(expectedVersion) != version {
+		return version, fault.Wrapf(ErrConflict, fault.Conflict, "%s is at version %d, expected %d", stream, version, expectedVersion)
+	}
+
+	for _, e := range events {
+		env, err := codec.EncodeEvent(e)
+		if err != nil {
+			return version, err
+		}
+
+		rec := &fileRecord{
+			Stream:   stream,
+			Version:  version + uint64(len(records)) + 1,
+			Position: uint64(len(s.offsets)+len(records)) + 1,
+			Time:     time.Now(),
+			Event:    env,
+			Commit:   len(records) == len(events)-1,
+		}
+		line, err := formatLine(rec)
+		if err != nil {
+			return version, err
+		}
+
+		offsets = append(offsets, s.size+int64(buf.Len()))
+		records = append(records, rec)
+		buf.Write(line)
+	}
+
+	if _, err := s.file.Write(buf.Bytes()); err != nil {
+		s.rollback()
+		return version, err
+	} else if err = s.file.Sync(); err != nil {
+		s.rollback()
+		return version, err
+	}
+
+	for i, rec := range records {
+		s.index(rec, offsets[i])
+	}
+	s.size += int64(buf.Len())
+	return version + uint64(len(records)), nil
+}
+
+// rollback cuts off a partially written append.
+func (s *FileStore) rollback() {
+	if err := s.file.Truncate(s.size); err != nil {
+		logger.Errorf("%s: failed to roll back append: %s", s.file.Name(), err)
+	} else if _, err = s.file.Seek(s.size, io.SeekStart); err != nil {
+		logger.Errorf("%s: failed to roll back append: %s", s.file.Name(), err)
+	}
+}
+
+// ReadStream implements Store.
+func (s *FileStore) ReadStream(stream aggregate.ID, fromVersion uint64) ([]Record, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	positions := s.streams[stream]
+	if fromVersion < 1 {
+		fromVersion = 1
+	}
+	if fromVersion > uint64(len(positions)) {
+		return nil, nil
+	}
+	return s.read(positions[fromVersion-1:])
+}
+
+// ReadAll implements Store.
+func (s *FileStore) ReadAll(fromPosition uint64) ([]Record, error) {
+	var positions []uint64
+
+	s.Lock()
+	defer s.Unlock()
+
+	if fromPosition < 1 {
+		fromPosition = 1
+	}
+	for p := fromPosition; p <= uint64(len(s.offsets)); p++ {
+		positions = append(positions, p)
+	}
+	return s.read(positions)
+}
+
+// read returns the records at global @positions.
+func (s *FileStore) read(positions []uint64) ([]Record, error) {
+	var records = make([]Record, 0, len(positions))
+
+	for _, p := range positions {
+		line, err := s.readLine(s.offsets[p-1])
+		if err != nil {
+			return nil, err
+		}
+
+		rec, err := parseLine(line)
+		if err != nil {
+			return nil, fault.Wrapf(err, fault.Internal, "record #%d", p)
+		}
+
+		e, err := codec.DecodeEvent(rec.Event)
+		if err != nil {
+			return nil, fault.Wrapf(err, fault.Internal, "record #%d", p)
+		}
+		records = append(records, Record{Stream: rec.Stream, Version: rec.Version, Position: rec.Position, Time: rec.Time, Event: e})
+	}
+	return records, nil
+}
+
+// readLine returns the line starting at @offset.
+func (s *FileStore) readLine(offset int64) ([]byte, error) {
+	var end = s.size
+
+	if i := sortSearch(s.offsets, offset); i+1 < len(s.offsets) {
+		end = s.offsets[i+1]
+	}
+
+	line := make([]byte, end-offset)
+	if _, err := s.file.ReadAt(line, offset); err != nil {
+		return nil, err
+	}
+	return line, nil
+}
+
+// sortSearch returns the index of @offset within the sorted @offsets.
+func sortSearch(offsets []int64, offset int64) int {
+	lo, hi := 0, len(offsets)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if offsets[mid] < offset {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// Close implements Store.
+func (s *FileStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.file.Close()
+}
+
+// formatLine returns the checksummed on-disk representation of @rec.
+func formatLine(rec *fileRecord) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(data), data)), nil
+}
+
+// parseLine verifies and decodes the on-disk record @line.
+func parseLine(line []byte) (*fileRecord, error) {
+	var rec = new(fileRecord)
+
+	if len(line) < 10 || line[8] != ' ' || line[len(line)-1] != '\n' {
+		return nil, fault.New(fault.Internal, "incomplete record")
+	}
+
+	data := line[9 : len(line)-1]
+	if sum, err := strconv.ParseUint(string(line[:8]), 16, 32); err != nil {
+		return nil, fault.New(fault.Internal, "invalid checksum %q", line[:8])
+	} else if uint32(sum) != crc32.ChecksumIEEE(data) {
+		return nil, fault.New(fault.Internal, "checksum mismatch")
+	} else if err = json.Unmarshal(data, rec); err != nil {
+		return nil, fault.Wrapf(err, fault.Internal, "invalid record")
+	}
+	return rec, nil
+}
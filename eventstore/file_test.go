@@ -0,0 +1,148 @@
+package eventstore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/codec"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// Test event
+type counted struct {
+	Src aggregate.ID
+	N   int
+}
+
+func (c *counted) Source() aggregate.ID { return c.Src }
+func (c *counted) Dest() aggregate.ID   { return aggregate.ID{} }
+
+func init() {
+	if err := codec.RegisterEvent(&counted{}); err != nil {
+		panic(err)
+	}
+}
+
+// openTestStore opens the FileStore at @path.
+func openTestStore(t *testing.T, path string) *FileStore {
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", path, err)
+	}
+	return s
+}
+
+// checkStream verifies that @stream of @s consists of counted events with N = 1 .. @expected.
+func checkStream(t *testing.T, s Store, stream aggregate.ID, expected int) {
+	records, err := s.ReadStream(stream, 1)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", stream, err)
+	} else if len(records) != expected {
+		t.Fatalf("expected %d records in %s, got %d", expected, stream, len(records))
+	}
+
+	for i, rec := range records {
+		if rec.Stream != stream || rec.Version != uint64(i+1) {
+			t.Fatalf("unexpected record %s", rec)
+		} else if c, ok := rec.Event.(*counted); !ok || c.N != i+1 || c.Src != stream {
+			t.Fatalf("unexpected event in %s", rec)
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	var a = aggregate.NewID(aggregate.ResourceType_CPU, "a")
+	var b = aggregate.NewID(aggregate.ResourceType_CPU, "b")
+
+	dir, err := os.MkdirTemp("", "eventstore")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events")
+	s := openTestStore(t, path)
+
+	// 1. Appending with the expected version
+	if v, err := s.Append(a, 0, &counted{a, 1}, &counted{a, 2}); err != nil {
+		t.Fatalf("failed to append to %s: %s", a, err)
+	} else if v != 2 {
+		t.Fatalf("expected %s at version 2, got %d", a, v)
+	} else if _, err = s.Append(b, AnyVersion, &counted{b, 1}); err != nil {
+		t.Fatalf("failed to append to %s: %s", b, err)
+	} else if _, err = s.Append(a, 2, &counted{a, 3}); err != nil {
+		t.Fatalf("failed to append to %s: %s", a, err)
+	}
+
+	// 2. Appending with a stale version
+	if _, err := s.Append(a, 2, &counted{a, 4}); !errors.Is(err, ErrConflict) || fault.CodeOf(err) != fault.Conflict {
+		t.Fatalf("expected version conflict, got %v", err)
+	}
+
+	checkStream(t, s, a, 3)
+	checkStream(t, s, b, 1)
+
+	if records, err := s.ReadStream(a, 3); err != nil || len(records) != 1 || records[0].Version != 3 {
+		t.Fatalf("unexpected result reading %s from version 3: %v, %v", a, records, err)
+	} else if records, err = s.ReadAll(3); err != nil || len(records) != 2 {
+		t.Fatalf("unexpected result reading from position 3: %v, %v", records, err)
+	} else if records[0].Stream != b || records[1].Stream != a || records[1].Position != 4 {
+		t.Fatalf("records out of order: %v", records)
+	}
+	s.Close()
+
+	// 3. Simulate a crash in the middle of an append of two events: only the first one is complete.
+	s = openTestStore(t, path)
+	if _, err := s.Append(b, 1, &counted{b, 2}, &counted{b, 3}); err != nil {
+		t.Fatalf("failed to append to %s: %s", b, err)
+	}
+	s.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+	torn := append(data[:bytes.LastIndexByte(data[:len(data)-1], '\n')+1], `0badf00d {"stream":`...)
+	if err = os.WriteFile(path, torn, 0644); err != nil {
+		t.Fatalf("failed to corrupt %s: %s", path, err)
+	}
+
+	s = openTestStore(t, path)
+	defer s.Close()
+
+	checkStream(t, s, a, 3)
+	checkStream(t, s, b, 1)
+	if v, err := s.Append(b, 1, &counted{b, 2}); err != nil || v != 2 {
+		t.Fatalf("failed to append to %s after recovery: %d, %v", b, v, err)
+	}
+	checkStream(t, s, b, 2)
+
+	if records, err := s.ReadAll(1); err != nil || len(records) != 5 {
+		t.Fatalf("expected 5 records after recovery, got %d (%v)", len(records), err)
+	}
+	s.Close()
+
+	// 4. A corrupted record in the middle of the file fails the opening, without losing any records.
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+	corrupted := append([]byte(nil), data...)
+	corrupted[20] ^= 0x01
+	if err = os.WriteFile(path, corrupted, 0644); err != nil {
+		t.Fatalf("failed to corrupt %s: %s", path, err)
+	}
+
+	if s, err := OpenFile(path); err == nil {
+		s.Close()
+		t.Fatalf("expected opening of corrupted %s to fail", path)
+	} else if fault.CodeOf(err) != fault.Internal {
+		t.Fatalf("expected internal error opening corrupted %s, got %v", path, err)
+	} else if after, _ := os.ReadFile(path); !bytes.Equal(after, corrupted) {
+		t.Fatalf("corrupted %s was modified on opening", path)
+	}
+}
@@ -0,0 +1,61 @@
+// Package eventstore persists the events published on the bus, organized as one stream per Aggregate.
+package eventstore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// AnyVersion disables the optimistic concurrency check of Append.
+const AnyVersion int64 = -1
+
+// ErrConflict is returned by Append if the stream version does not match the expected version.
+var ErrConflict error = fault.New(fault.Conflict, "stream version conflict")
+
+// Record is an event, as stored in a Store.
+type Record struct {
+	Stream   aggregate.ID // The Aggregate whose stream the event belongs to
+	Version  uint64       // Position within Stream, starting at 1
+	Position uint64       // Position across all streams, starting at 1
+	Time     time.Time    // When the event was appended
+	Event    event.Event
+}
+
+func (r Record) String() string {
+	return fmt.Sprintf("%s@%d (#%d): %v", r.Stream, r.Version, r.Position, r.Event)
+}
+
+// Store is an append-only store of event streams.
+type Store interface {
+	// Append adds @events to @stream, provided that @stream currently is at @expectedVersion
+	// (0 meaning that @stream is empty). Otherwise ErrConflict is returned, unless
+	// @expectedVersion is AnyVersion. Returns the new version of @stream.
+	Append(stream aggregate.ID, expectedVersion int64, events ...event.Event) (version uint64, err error)
+
+	// ReadStream returns the records of @stream, starting at @fromVersion.
+	ReadStream(stream aggregate.ID, fromVersion uint64) ([]Record, error)
+
+	// ReadAll returns the records of all streams in order of appending, starting at global @fromPosition.
+	ReadAll(fromPosition uint64) ([]Record, error)
+
+	// Close releases the resources held by the Store
+	Close() error
+}
+
+// History returns the events of @stream from @s, in the order they were appended.
+func History(s Store, stream aggregate.ID) ([]event.Event, error) {
+	records, err := s.ReadStream(stream, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]event.Event, len(records))
+	for i := range records {
+		events[i] = records[i].Event
+	}
+	return events, nil
+}
@@ -0,0 +1,93 @@
+package magicbus
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Once ends a subscription after the first matching event.
+func Once() SubscribeOption {
+	return Count(1)
+}
+
+// Count ends a subscription after @n matching events have been delivered. It panics unless @n > 0.
+func Count(n int) SubscribeOption {
+	if n <= 0 {
+		panic(fmt.Sprintf("magicbus: invalid subscription count %d", n))
+	}
+	return func(s *subscription) {
+		s.remaining = n
+	}
+}
+
+// Until ends a subscription at @deadline.
+func Until(deadline time.Time) SubscribeOption {
+	return func(s *subscription) {
+		s.deadline = deadline
+	}
+}
+
+// UntilDone ends a subscription when @ctx is done.
+func UntilDone(ctx context.Context) SubscribeOption {
+	return func(s *subscription) {
+		s.ctx = ctx
+	}
+}
+
+// watchExpiry removes subscription @id from @m once its context is done or its deadline has passed.
+// Must be called from within the actor loop of @m, after registering @sub.
+func (m *MagicBus) watchExpiry(id SubscriptionID, sub *subscription) {
+	if sub.ctx == nil && sub.deadline.IsZero() {
+		return
+	} else if sub.ctx == nil {
+		sub.ctx = context.Background()
+	}
+
+	if sub.deadline.IsZero() {
+		sub.ctx, sub.cancel = context.WithCancel(sub.ctx)
+	} else {
+		sub.ctx, sub.cancel = context.WithDeadline(sub.ctx, sub.deadline)
+	}
+
+	go func(ctx context.Context) {
+		select {
+		case <-ctx.Done():
+			if err := m.expire(id); err != nil && m.IsActive() {
+				logger.Errorf("magicbus: failed to remove expired subscription %s: %s", id, err)
+			}
+		case <-m.Context().Done():
+		}
+	}(sub.ctx)
+}
+
+// expire ends subscription @id of @m, after delivering the events already queued for it.
+func (m *MagicBus) expire(id SubscriptionID) error {
+	return <-m.Action(func() error {
+		sub, ok := m.subscriptions[id.String()]
+		if !ok { // ended in the meantime
+			return nil
+		} else if err := m.endSubscription(id.String(), sub, false); err != nil {
+			return err
+		} else if sub.Actor != nil {
+			return sub.Publish(queuedEvent{last: true})
+		}
+		return nil
+	})
+}
+
+// endSubscription removes subscription @sub, stored under @key, from @m.
+// If @discard is set, events not yet delivered to @sub are discarded; otherwise
+// the caller is responsible for ending the delivery queue of @sub (see queuedEvent.last).
+// Must be called from within the actor loop of @m.
+func (m *MagicBus) endSubscription(key string, sub *subscription, discard bool) error {
+	delete(m.subscriptions, key)
+
+	if sub.cancel != nil {
+		sub.cancel()
+	}
+	if discard && sub.Actor != nil {
+		return sub.Shutdown()
+	}
+	return nil
+}
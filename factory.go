@@ -0,0 +1,118 @@
+package magicbus
+
+import (
+	"reflect"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// factory creates specific Aggregates of a ResourceType on demand.
+type factory struct {
+	// Creates the Aggregate for a specific ID
+	create aggregate.Factory
+
+	// Registration settings of the created Aggregates
+	registration
+}
+
+// RegisterFactory lets @f create the specific Aggregates of type @rt on the local bus.
+// @opts: registration settings of the created Aggregates
+func RegisterFactory(rt aggregate.ResourceType, f aggregate.Factory, opts ...RegisterOption) {
+	if err := localBus.RegisterFactory(rt, f, opts...); err != nil {
+		logger.Fatalf("%s: factory registration failed: %s", rt, err)
+	}
+}
+
+// UnregisterFactory removes the factory of ResourceType @rt from the local bus.
+func UnregisterFactory(rt aggregate.ResourceType) {
+	if err := localBus.UnregisterFactory(rt); err != nil {
+		logger.Fatalf("%s: factory de-registration failed: %s", rt, err)
+	}
+}
+
+// RegisterFactory sets @f to create the specific Aggregates of type @rt on @m.
+//
+// The first command to a specific aggregate ID (ID != "") of type @rt, which is not registered
+// yet, creates the Aggregate via @f, registers it as ready, and hands the command off to it.
+// This takes precedence over the general subsystem of @rt (ID == ""), if any.
+// Unless @opts include WithFactory, @f also re-creates the Aggregates on a Restart directive.
+func (m *MagicBus) RegisterFactory(rt aggregate.ResourceType, f aggregate.Factory, opts ...RegisterOption) error {
+	if f == nil {
+		return fault.New(fault.Rejected, "attempt to register a nil factory for %s", rt)
+	}
+	return m.registerFactory(rt, &factory{create: f}, opts)
+}
+
+// registerFactory registers @fac for type @rt, configured by @opts.
+func (m *MagicBus) registerFactory(rt aggregate.ResourceType, fac *factory, opts []RegisterOption) error {
+	for _, opt := range opts {
+		opt(&fac.registration)
+	}
+	if fac.registration.factory == nil {
+		fac.registration.factory = fac.create
+	}
+
+	return <-m.Action(func() error {
+		if _, exists := m.factories[rt]; exists {
+			return fault.New(fault.Conflict, "factory for %s already registered", rt)
+		}
+		m.factories[rt] = fac
+		return nil
+	})
+}
+
+// UnregisterFactory stops @m from creating Aggregates of type @rt.
+// Aggregates created by the factory so far remain registered.
+func (m *MagicBus) UnregisterFactory(rt aggregate.ResourceType) error {
+	return <-m.Action(func() error {
+		delete(m.factories, rt)
+		return nil
+	})
+}
+
+// spawn creates and registers the Aggregate @id via @f. Must be called from within the actor loop of @m.
+func (m *MagicBus) spawn(id aggregate.ID, f *factory) (*aggregateActor, error) {
+	var reg = f.registration
+
+	if f.create != nil {
+		reg.factory = f.create
+	}
+	a, err := m.create(id, reg)
+	if err != nil {
+		return nil, err
+	}
+	return m.register(a, true, f.registration), nil
+}
+
+// create creates Aggregate @id via the factory of @reg.
+// Event-sourced Aggregates are wrapped, and rehydrated from their history (see RegisterEventSourced).
+func (m *MagicBus) create(id aggregate.ID, reg registration) (aggregate.Aggregate, error) {
+	if reg.sourcedFactory != nil {
+		return m.recreate(id, reg.sourcedFactory)
+	} else if reg.factory == nil {
+		return nil, fault.New(fault.Internal, "%s: no factory to create aggregate", id)
+	}
+
+	a, err := reg.factory(id)
+	if err != nil {
+		return nil, fault.Wrapf(err, fault.Internal, "%s: failed to create aggregate", id)
+	} else if isNil(a) {
+		return nil, fault.New(fault.Internal, "%s: factory returned a nil aggregate", id)
+	} else if a.AggregateID() != id {
+		return nil, fault.New(fault.Internal, "%s: factory returned mismatching aggregate", id)
+	}
+	return a, nil
+}
+
+// isNil returns true if @v is nil, or a nil value of a concrete type, such as (*T)(nil).
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package magicbus
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+)
+
+// Default upper bound of the time taken by the follow-up commands of a command without deadline
+const defaultFollowUpTimeout = time.Minute
+
+// followUpContext returns the context of the follow-up commands of @cmd, which ends with the context of @cmd.
+// Unless @cmd has a deadline of its own, it is bounded by the follow-up timeout of @m (see WithFollowUpTimeout).
+func (m *MagicBus) followUpContext(cmd *aggregate.Command) (context.Context, context.CancelFunc) {
+	if _, ok := cmd.Context().Deadline(); ok {
+		return context.WithCancel(cmd.Context())
+	}
+	return context.WithTimeout(cmd.Context(), m.followUpTimeout)
+}
+
+// runFollowUp runs the next-step command @next within @ctx, and returns the completion of each command it consists of.
+// If @next is an aggregate.FanOut, its branches run in parallel, followed by its join if all branches succeeded.
+// The branches and the join inherit the metadata of @next.
+func (m *MagicBus) runFollowUp(ctx context.Context, next *aggregate.Command) []*event.CommandDone {
+	fo, ok := next.Data().(aggregate.FanOut)
+	if !ok {
+		return []*event.CommandDone{m.await(ctx, next)}
+	}
+
+	var done = make([]*event.CommandDone, len(fo.Branches))
+	var wg sync.WaitGroup
+
+	for i, branch := range fo.Branches {
+		branch.InheritMetadata(next.Metadata())
+		wg.Add(1)
+		go func(i int, branch *aggregate.Command) {
+			defer wg.Done()
+			done[i] = m.await(ctx, branch)
+		}(i, branch)
+	}
+	wg.Wait()
+
+	if fo.Join == nil {
+		return done
+	}
+	for _, cd := range done {
+		if cd.Error != "" {
+			logger.Warningf("magicbus: skipping %s, since %s failed", fo.Join, cd.Desc)
+			return done
+		}
+	}
+	return append(done, m.await(ctx, fo.Join.InheritMetadata(next.Metadata())))
+}
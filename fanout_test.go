@@ -0,0 +1,192 @@
+package magicbus
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+	"github.com/pkg/errors"
+)
+
+func TestFanOut(t *testing.T) {
+	var cpus = &pool{id: aggregate.NewID(aggregate.ResourceType_CPU, "fanout"), capacity: 8}
+	var memory = &pool{id: aggregate.NewID(aggregate.ResourceType_MEMORY, "fanout"), capacity: 1024}
+	var planner = &plannerAggregate{id: aggregate.NewID(aggregate.ResourceType_CPU, "planner"), cpus: cpus.id, memory: memory.id}
+
+	m := NewMagicBus(context.Background())
+	defer m.Shutdown()
+
+	for _, a := range []aggregate.Aggregate{cpus, memory, planner} {
+		if err := m.Register(a, true); err != nil {
+			t.Fatalf("failed to register %s: %s", a.AggregateID(), err)
+		}
+	}
+
+	// Both branches succeed, followed by the join.
+	cmd, _ := aggregate.NewLocalCommand(planner.id, provision{CPUs: 2, Bytes: 512})
+	res := m.LaunchWait(cmd, time.Second)
+	if res.Err != nil {
+		t.Fatalf("%s failed: %s", cmd, res.Err)
+	} else if len(res.Branches) != 3 {
+		t.Fatalf("expected 2 branches and join, got %v", res.Branches)
+	}
+	for i, expected := range []interface{}{uint64(2), uint64(512), "done"} {
+		if b := res.Branches[i]; b.Err != nil || b.Result != expected {
+			t.Fatalf("branch #%d: expected %v, got %s", i, expected, b)
+		}
+	}
+	if ops := planner.log(); !reflect.DeepEqual(ops, []string{"provision", "finish"}) {
+		t.Fatalf("unexpected planner operations %v", ops)
+	}
+
+	// A failed branch skips the join, and is reported apart from the outcome of the command itself.
+	cmd, _ = aggregate.NewLocalCommand(planner.id, provision{CPUs: 2, Bytes: 1024})
+	if res = m.LaunchWait(cmd, time.Second); res.Err != nil {
+		t.Fatalf("%s failed: %s", cmd, res.Err)
+	} else if res.BranchErr() == nil {
+		t.Fatalf("expected a branch of %s to fail", cmd)
+	} else if len(res.Branches) != 2 || res.Branches[0].Err != nil || res.Branches[1].Err == nil {
+		t.Fatalf("unexpected branch results %v", res.Branches)
+	}
+
+	// A linear next step is reported as the only branch.
+	cmd, _ = aggregate.NewLocalCommand(planner.id, "chain")
+	if res = m.LaunchWait(cmd, time.Second); res.Err != nil {
+		t.Fatalf("%s failed: %s", cmd, res.Err)
+	} else if len(res.Branches) != 1 || res.Branches[0].Result != "done" {
+		t.Fatalf("unexpected branch results %v", res.Branches)
+	}
+}
+
+func TestFanOutTimeout(t *testing.T) {
+	var cpus = &pool{id: aggregate.NewID(aggregate.ResourceType_CPU, "timeout"), capacity: 8}
+	var memory = &pool{id: aggregate.NewID(aggregate.ResourceType_MEMORY, "timeout"), capacity: 1024}
+	var planner = &plannerAggregate{id: aggregate.NewID(aggregate.ResourceType_CPU, "planner"), cpus: cpus.id, memory: memory.id}
+
+	m := NewMagicBus(context.Background(), WithFollowUpTimeout(50*time.Millisecond))
+	defer m.Shutdown()
+
+	// The memory pool is not ready, hence its branch does not complete.
+	for _, a := range []aggregate.Aggregate{cpus, memory, planner} {
+		if err := m.Register(a, a != memory); err != nil {
+			t.Fatalf("failed to register %s: %s", a.AggregateID(), err)
+		}
+	}
+
+	cmd, _ := aggregate.NewLocalCommand(planner.id, provision{CPUs: 2, Bytes: 512})
+	if res := m.LaunchWait(cmd, time.Second); res.Err != nil {
+		t.Fatalf("%s failed: %s", cmd, res.Err)
+	} else if err := res.BranchErr(); fault.CodeOf(err) != fault.Timeout {
+		t.Fatalf("expected the memory branch to time out, got %v", err)
+	}
+}
+
+func TestFanOutCompensation(t *testing.T) {
+	var cpus = &pool{id: aggregate.NewID(aggregate.ResourceType_CPU, "compensated"), capacity: 8}
+	var memory = &pool{id: aggregate.NewID(aggregate.ResourceType_MEMORY, "compensated"), capacity: 1024}
+	var planner = &plannerAggregate{id: aggregate.NewID(aggregate.ResourceType_CPU, "planner"), cpus: cpus.id, memory: memory.id}
+
+	m := NewMagicBus(context.Background())
+	defer m.Shutdown()
+
+	for _, a := range []aggregate.Aggregate{cpus, memory, planner} {
+		if err := m.Register(a, true); err != nil {
+			t.Fatalf("failed to register %s: %s", a.AggregateID(), err)
+		}
+	}
+
+	sid, err := m.RegisterProcessManager(&planning{planner: planner.id})
+	if err != nil {
+		t.Fatalf("failed to register process manager: %s", err)
+	}
+	defer m.Unsubscribe(sid)
+
+	// The provision step succeeds, but its memory branch fails, hence the step is compensated.
+	if err = m.Publish(&provisionRequested{Name: "vm", CPUs: 2, Bytes: 2048}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	var expected = []string{"provision", "cancel"}
+	for start := time.Now(); !reflect.DeepEqual(planner.log(), expected); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("expected planner operations %v, got %v", expected, planner.log())
+		}
+	}
+}
+
+// planning runs a single provision step on the planner for each provisionRequested event.
+type planning struct {
+	planner aggregate.ID
+}
+
+func (p *planning) Correlate(e event.Event) string {
+	if req, ok := e.(*provisionRequested); ok {
+		return req.Name
+	}
+	return ""
+}
+
+func (p *planning) Start(id string, e event.Event) (Process, error) {
+	return p, nil
+}
+
+func (p *planning) Handle(e event.Event) ([]Step, bool, error) {
+	if req, ok := e.(*provisionRequested); ok {
+		cmd, _ := aggregate.NewLocalCommand(p.planner, provision{CPUs: req.CPUs, Bytes: req.Bytes})
+		undo, _ := aggregate.NewLocalCommand(p.planner, "cancel")
+		return []Step{{Command: cmd, Compensation: undo}}, false, nil
+	}
+	return nil, true, nil
+}
+
+// provision requests CPUs and memory from the plannerAggregate.
+type provision struct {
+	CPUs  uint64
+	Bytes uint64
+}
+
+// plannerAggregate reserves CPUs and memory in parallel, then finishes.
+type plannerAggregate struct {
+	id           aggregate.ID
+	cpus, memory aggregate.ID
+
+	mu  sync.Mutex
+	ops []string
+}
+
+func (p *plannerAggregate) AggregateID() aggregate.ID { return p.id }
+
+func (p *plannerAggregate) HandleCommand(cmd *aggregate.Command) (*aggregate.Command, interface{}, error) {
+	p.mu.Lock()
+	p.ops = append(p.ops, cmd.Type())
+	p.mu.Unlock()
+
+	switch c := cmd.Data().(type) {
+	case provision:
+		cpus, _ := aggregate.NewCommand(p.id, p.cpus, reserve{Amount: c.CPUs})
+		memory, _ := aggregate.NewCommand(p.id, p.memory, reserve{Amount: c.Bytes})
+		join, _ := aggregate.NewLocalCommand(p.id, "finish")
+
+		next, err := aggregate.NewFanOut(p.id, join, cpus, memory)
+		return next, nil, err
+	case string:
+		if c == "chain" {
+			next, err := aggregate.NewLocalCommand(p.id, "finish")
+			return next, nil, err
+		}
+		return nil, "done", nil
+	}
+	return nil, nil, errors.Errorf("unsupported command %s", cmd)
+}
+
+// log returns the commands handled by @p so far.
+func (p *plannerAggregate) log() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.ops...)
+}
@@ -0,0 +1,147 @@
+// Package fault defines the structured errors reported by commands, which keep their
+// code, message and details when sent to remote buses or persisted.
+package fault
+
+import "fmt"
+
+// Code classifies an Error. Errors with the same non-empty Code match via errors.Is.
+type Code string
+
+// Codes of the errors reported by the bus
+const (
+	NotFound    Code = "not-found"   // No such aggregate, subscription, handler, ...
+	Rejected    Code = "rejected"    // Invalid request, e.g. a nil command
+	Timeout     Code = "timeout"     // Gave up waiting for completion
+	Canceled    Code = "canceled"    // Canceled by the issuer
+	Unavailable Code = "unavailable" // Temporarily unable to process, e.g. shut down or mailbox full
+	Conflict    Code = "conflict"    // Concurrent modification, e.g. of an event stream
+	Internal    Code = "internal"    // Bug or panic in the bus or in an aggregate
+)
+
+// Sentinel errors, to match errors by code via errors.Is
+var (
+	ErrNotFound    = New(NotFound, "not found")
+	ErrRejected    = New(Rejected, "rejected")
+	ErrTimeout     = New(Timeout, "timed out")
+	ErrCanceled    = New(Canceled, "canceled")
+	ErrUnavailable = New(Unavailable, "unavailable")
+	ErrConflict    = New(Conflict, "conflict")
+	ErrInternal    = New(Internal, "internal error")
+)
+
+// Coder is implemented by errors which are not an Error, but have a Code.
+type Coder interface {
+	FaultCode() Code
+}
+
+// Error is a structured error.
+type Error struct {
+	Code    Code              `json:"code,omitempty"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+
+	// Original error (local only, not encoded)
+	cause error
+}
+
+// New returns a new Error with @code and a message built from @format and @args.
+func New(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap returns an Error with the message of @err, which unwraps to @err.
+// Its code is that of @err (see CodeOf), or @code if @err has none.
+func Wrap(err error, code Code) *Error {
+	if err == nil {
+		return nil
+	} else if c := CodeOf(err); c != "" {
+		code = c
+	}
+	return &Error{Code: code, Message: err.Error(), Details: detailsOf(err), cause: err}
+}
+
+// Wrapf is Wrap with the message of @err prefixed by @format and @args.
+func Wrapf(err error, code Code, format string, args ...interface{}) *Error {
+	if err == nil {
+		return nil
+	}
+	f := Wrap(err, code)
+	f.Message = fmt.Sprintf(format, args...) + ": " + f.Message
+	return f
+}
+
+// From converts @err into an Error. If @err is not an Error itself, the result has the message of
+// @err, unwraps to @err, and takes code and details from the chain of @err (if any).
+func From(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return Wrap(err, "")
+}
+
+// CodeOf returns the Code of the first Error or Coder in the chain of @err ("" if none).
+// The chain is followed via Unwrap, as well as via Cause (github.com/pkg/errors).
+func CodeOf(err error) Code {
+	for ; err != nil; err = next(err) {
+		if e, ok := err.(*Error); ok && e.Code != "" {
+			return e.Code
+		} else if c, ok := err.(Coder); ok {
+			return c.FaultCode()
+		}
+	}
+	return ""
+}
+
+// Permanent returns true if @err is known not to go away on retrying.
+func Permanent(err error) bool {
+	switch CodeOf(err) {
+	case NotFound, Rejected, Canceled:
+		return true
+	}
+	return false
+}
+
+// detailsOf returns the details of the first Error in the chain of @err.
+func detailsOf(err error) map[string]string {
+	for ; err != nil; err = next(err) {
+		if e, ok := err.(*Error); ok {
+			return e.Details
+		}
+	}
+	return nil
+}
+
+// next returns the error wrapped by @err (nil if none).
+func next(err error) error {
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		return e.Unwrap()
+	case interface{ Cause() error }:
+		return e.Cause()
+	}
+	return nil
+}
+
+// WithDetail adds detail @key = @value to @e, and returns @e.
+func (e *Error) WithDetail(key, value string) *Error {
+	if e.Details == nil {
+		e.Details = map[string]string{}
+	}
+	e.Details[key] = value
+	return e
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the original error, if @e was created from one on this node.
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether @target is an Error with the same (non-empty) Code as @e.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code != "" && t.Code == e.Code
+}
@@ -0,0 +1,70 @@
+package fault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestFault(t *testing.T) {
+	err := New(NotFound, "no such bank %q", "bank3").WithDetail("bank", "bank3")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %v to match by code", err)
+	} else if errors.Is(err, ErrConflict) {
+		t.Fatalf("expected %v not to match a different code", err)
+	} else if err.Error() != `no such bank "bank3"` {
+		t.Fatalf("unexpected message %q", err)
+	}
+
+	// The code, message and details survive encoding; the cause does not.
+	b, _ := json.Marshal(Wrap(io.EOF, Unavailable))
+	var decoded *Error
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("failed to decode %s: %s", b, err)
+	} else if !reflect.DeepEqual(decoded, &Error{Code: "unavailable", Message: io.EOF.Error()}) {
+		t.Fatalf("error garbled: %#v", decoded)
+	}
+
+	// Conversion keeps the original error locally.
+	if f := From(fmt.Errorf("reading: %w", io.EOF)); !errors.Is(f, io.EOF) || f.Code != "" {
+		t.Fatalf("unexpected conversion %#v", f)
+	} else if f = From(err); f != err {
+		t.Fatalf("expected conversion of %v to be a no-op", err)
+	} else if CodeOf(fmt.Errorf("lookup: %w", err)) != NotFound {
+		t.Fatalf("expected code of wrapped %v", err)
+	}
+}
+
+func TestCodes(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		code      Code
+		permanent bool
+	}{
+		{io.EOF, "", false},
+		{pkgerrors.Wrap(ErrConflict, "appending"), Conflict, false},
+		{fmt.Errorf("lookup: %w", New(NotFound, "no such bank")), NotFound, true},
+		{Wrapf(New(Rejected, "nil command"), Internal, "submitting"), Rejected, true},
+		{Wrap(io.EOF, Unavailable), Unavailable, false},
+		{coded(Canceled), Canceled, true},
+	} {
+		if code := CodeOf(tc.err); code != tc.code {
+			t.Fatalf("%v: expected code %q, got %q", tc.err, tc.code, code)
+		} else if Permanent(tc.err) != tc.permanent {
+			t.Fatalf("%v: expected permanent = %t", tc.err, tc.permanent)
+		} else if f := From(tc.err); f.Code != tc.code || f.Error() != tc.err.Error() {
+			t.Fatalf("%v: unexpected conversion %#v", tc.err, f)
+		}
+	}
+}
+
+// coded is an error which has a Code without being an Error.
+type coded Code
+
+func (c coded) Error() string   { return string(c) }
+func (c coded) FaultCode() Code { return Code(c) }
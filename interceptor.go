@@ -0,0 +1,58 @@
+package magicbus
+
+import (
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+)
+
+// CommandHandler handles @cmd on behalf of an Aggregate (see aggregate.Aggregate.HandleCommand).
+type CommandHandler func(cmd *aggregate.Command) (next *aggregate.Command, result interface{}, err error)
+
+// CommandInterceptor wraps the handling of @cmd by an Aggregate, e.g. for logging, validation
+// or authorization. It continues the chain by calling @next, or short-circuits it by returning
+// without doing so. Runs within the actor loop of the Aggregate.
+type CommandInterceptor func(cmd *aggregate.Command, next CommandHandler) (*aggregate.Command, interface{}, error)
+
+// PublishFunc publishes @e on the bus.
+type PublishFunc func(e event.Event) error
+
+// PublishInterceptor wraps the publishing of @e on the bus. It continues the chain by calling
+// @next, or drops @e by returning without doing so.
+type PublishInterceptor func(e event.Event, next PublishFunc) error
+
+// DeliverInterceptor wraps the delivery of @e to an Aggregate or subscriber. It continues the
+// chain by calling @next, or suppresses the delivery by returning without doing so.
+type DeliverInterceptor func(e event.Event, next event.Handler)
+
+// chainCommand returns @final, wrapped by @interceptors (the first one being outermost).
+func chainCommand(interceptors []CommandInterceptor, final CommandHandler) CommandHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		intercept, next := interceptors[i], final
+		final = func(cmd *aggregate.Command) (*aggregate.Command, interface{}, error) {
+			return intercept(cmd, next)
+		}
+	}
+	return final
+}
+
+// chainPublish returns @final, wrapped by @interceptors (the first one being outermost).
+func chainPublish(interceptors []PublishInterceptor, final PublishFunc) PublishFunc {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		intercept, next := interceptors[i], final
+		final = func(e event.Event) error {
+			return intercept(e, next)
+		}
+	}
+	return final
+}
+
+// chainDeliver returns @final, wrapped by @interceptors (the first one being outermost).
+func chainDeliver(interceptors []DeliverInterceptor, final event.Handler) event.Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		intercept, next := interceptors[i], final
+		final = func(e event.Event) {
+			intercept(e, next)
+		}
+	}
+	return final
+}
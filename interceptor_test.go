@@ -0,0 +1,97 @@
+package magicbus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/pkg/errors"
+)
+
+func TestInterceptors(t *testing.T) {
+	var mu sync.Mutex
+	var trace []string
+	var id = aggregate.NewID(aggregate.ResourceType_CPU, "intercepted")
+
+	record := func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		trace = append(trace, fmt.Sprintf(format, args...))
+	}
+
+	m := NewMagicBus(context.Background(),
+		WithCommandInterceptors(func(cmd *aggregate.Command, next CommandHandler) (*aggregate.Command, interface{}, error) {
+			record("bus:%s", cmd.Type())
+			return next(cmd)
+		}),
+		WithPublishInterceptors(func(e event.Event, next PublishFunc) error {
+			if te, ok := e.(*testEvent); ok && te.kind == "secret" {
+				return nil // drop
+			}
+			return next(e)
+		}),
+		WithDeliverInterceptors(func(e event.Event, next event.Handler) {
+			if te, ok := e.(*testEvent); ok {
+				record("deliver:%s", te.kind)
+			}
+			next(e)
+		}),
+	)
+	defer m.Shutdown()
+
+	// The aggregate only accepts "increment" commands.
+	authorize := func(cmd *aggregate.Command, next CommandHandler) (*aggregate.Command, interface{}, error) {
+		record("aggregate:%s", cmd.Type())
+		if cmd.Type() != "increment" {
+			return nil, nil, errors.Errorf("%s not authorized", cmd.Type())
+		}
+		return next(cmd)
+	}
+	if err := m.Register(&counterAggregate{id: id}, true, WithAggregateInterceptors(authorize)); err != nil {
+		t.Fatalf("failed to register %s: %s", id, err)
+	}
+
+	// 1. Commands pass the bus interceptors first, then those of the aggregate.
+	for _, typ := range []string{"increment", "reset"} {
+		cmd, _ := aggregate.NewLocalCommand(id, typ)
+		res := m.LaunchWait(cmd, time.Second)
+		if typ == "increment" && (res.Err != nil || res.Result != 1) {
+			t.Fatalf("unexpected result of %s: %s", cmd, res)
+		} else if typ == "reset" && res.Err == nil {
+			t.Fatalf("expected %s to be rejected", cmd)
+		}
+	}
+
+	// 2. Events pass the publish interceptors, then the deliver interceptors.
+	var received = make(chan event.Event, 2)
+	if _, err := m.Subscribe(func(e event.Event) { received <- e }, ByEventType(&testEvent{})); err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+	for _, kind := range []string{"secret", "public"} {
+		if err := m.Publish(mkTestEvent(id, id, kind)); err != nil {
+			t.Fatalf("failed to publish: %s", err)
+		}
+	}
+
+	select {
+	case e := <-received:
+		if e.(*testEvent).kind != "public" {
+			t.Fatalf("publish interceptor did not drop %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if got, expected := strings.Join(trace, " "),
+		"bus:increment aggregate:increment bus:reset aggregate:reset deliver:public"; got != expected {
+		t.Fatalf("unexpected interceptor trace: %q, expected %q", got, expected)
+	}
+}
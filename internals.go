@@ -3,11 +3,18 @@ package magicbus
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/grrtrr/magicbus/actor"
 	"github.com/grrtrr/magicbus/aggregate"
 	"github.com/grrtrr/magicbus/event"
-	"github.com/pkg/errors"
+	"github.com/grrtrr/magicbus/eventstore"
+	"github.com/grrtrr/magicbus/fault"
+	"github.com/grrtrr/magicbus/schedule"
+	"github.com/grrtrr/magicbus/trace"
+	"github.com/grrtrr/magicbus/transport"
 )
 
 // MagicBus serializes event/command notification on behalf of aggregates, allowing
@@ -19,55 +26,191 @@ type MagicBus struct {
 	// List of command-handling aggregates (map { AggregateID -> aggregateActor })
 	aggregates map[aggregate.ID]*aggregateActor
 
-	// List of event observers (map { SubscriptionID -> event.Handler })
-	observers map[string]event.Handler
+	// Event subscriptions (map { SubscriptionID -> subscription })
+	subscriptions map[string]*subscription
+
+	// Connection to remote buses (nil if disabled)
+	transport transport.Transport
+
+	// Commands and events which could not be delivered
+	deadLetters *deadLetters
+
+	// Settings of the internal actor
+	actorOptions []actor.Option
+
+	// Past events of event-sourced Aggregates (nil if none)
+	history event.History
+
+	// Persistent store of published events (nil if disabled)
+	store eventstore.Store
+
+	// Factories of specific aggregates (map { ResourceType -> factory })
+	factories map[aggregate.ResourceType]*factory
+
+	// Aggregates which have been passivated due to inactivity
+	passivated *passivation
+
+	// Commands currently being handled (map { AggregateID -> *aggregate.Command }), keyed by
+	// the Aggregate on whose behalf events published in the meantime are stamped (see Publish)
+	handling sync.Map
+
+	// Retry policies and state of failed commands
+	retries *retries
+
+	// Pending scheduled deliveries (map { ScheduleID -> scheduled }), and their persistent store (nil if disabled).
+	// The store is updated outside the actor loop; scheduleMu keeps its updates in the order of the schedule changes.
+	schedules     map[string]*scheduled
+	scheduleStore schedule.Store
+	scheduleMu    sync.Mutex
+
+	// Records the causal history of commands and events (nil if disabled)
+	recorder *Recorder
+
+	// Records the spans of commands and events (nil if disabled)
+	tracer trace.Tracer
+
+	// Upper bound of the follow-up commands of a command without deadline
+	followUpTimeout time.Duration
+
+	// Interceptor chains, outermost first
+	commandInterceptors []CommandInterceptor
+	publishInterceptors []PublishInterceptor
+	deliverInterceptors []DeliverInterceptor
 }
 
 // NewMagicBus instantiates a new bus instance ready to process commands/events.
-func NewMagicBus(ctx context.Context) *MagicBus {
+func NewMagicBus(ctx context.Context, opts ...Option) *MagicBus {
 	m := &MagicBus{
-		aggregates: map[aggregate.ID]*aggregateActor{},
-		observers:  map[string]event.Handler{},
+		aggregates:      map[aggregate.ID]*aggregateActor{},
+		subscriptions:   map[string]*subscription{},
+		factories:       map[aggregate.ResourceType]*factory{},
+		passivated:      newPassivation(defaultPassivationCapacity),
+		retries:         newRetries(),
+		schedules:       map[string]*scheduled{},
+		deadLetters:     newDeadLetters(defaultDeadLetterCapacity),
+		followUpTimeout: defaultFollowUpTimeout,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.store != nil && m.history == nil {
+		m.history = func(id aggregate.ID) ([]event.Event, error) {
+			return eventstore.History(m.store, id)
+		}
+	}
+	if m.tracer != nil {
+		m.actorOptions = append(m.actorOptions, m.queueTiming("bus"))
+	}
+	m.Actor = actor.New(ctx, m.commandHandler, m.eventHandler, true, m.actorOptions...)
+
+	if m.transport != nil {
+		go m.receive(m.transport.Receive())
+	}
+	if m.scheduleStore != nil {
+		m.restoreSchedules()
 	}
-	m.Actor = actor.New(ctx, m.commandHandler, m.eventHandler, true)
 	return m
 }
 
+// Shutdown stops the pending schedules of @m, and shuts @m down.
+// Persisted schedules are resumed by the next bus using the same schedule store (see WithScheduleStore).
+func (m *MagicBus) Shutdown() error {
+	if err := <-m.Action(func() error {
+		m.stopSchedules()
+		return nil
+	}); err != nil {
+		return err
+	}
+	return m.Actor.Shutdown()
+}
+
 // command-processing callback
 func (m *MagicBus) commandHandler(cmd *aggregate.Command) {
+	m.recordCommand(cmd)
+
+	span := m.startCommandSpan(trace.Extract(cmd.Metadata()), cmd, "route")
+	defer span.End()
+
+	ag, err := m.route(cmd)
+	if err == nil {
+		span.SetAttribute("aggregate", ag.AggregateID().String())
+		m.handoff(ag)
+		if err = ag.Submit(cmd); err != nil {
+			err = fault.Wrapf(err, fault.Unavailable, "%s: failed to submit %s", ag.AggregateID(), cmd)
+		}
+	}
+	if err != nil {
+		span.SetError(err)
+		m.deadLetterCommand(cmd, err)
+	}
+}
+
+// route returns the aggregateActor to handle @cmd. Must be called from within the actor loop of @m.
+func (m *MagicBus) route(cmd *aggregate.Command) (*aggregateActor, error) {
 	// Try most-specific match (Type + Node + ID) first
-	if ag, ok := m.aggregates[cmd.Dest()]; ok {
-		if err := ag.Submit(cmd); err != nil {
-			logger.Errorf("%s: failed to submit %v: %s", ag.AggregateID(), cmd, err)
+	ag, ok, err := m.lookup(cmd.Dest())
+	if err != nil {
+		return nil, err
+	} else if !ok && cmd.Dest().ID != "" {
+		if f, hasFactory := m.factories[cmd.Dest().Type]; hasFactory {
+			// Create a specific instance on first use
+			return m.spawn(cmd.Dest(), f)
 		}
-		return
-	} else if cmd.Dest().ID != "" {
 		// If there is no specific instance, try the general subsystem (ID == "")
-		if ag, ok := m.aggregates[aggregate.NewID(cmd.Dest().Type, "")]; ok {
-			if err := ag.Submit(cmd); err != nil {
-				logger.Errorf("%s: failed to submit %v: %s", ag.AggregateID(), cmd, err)
-			}
-			return
-		}
+		ag, ok = m.aggregates[aggregate.NewID(cmd.Dest().Type, "")]
 	}
 
 	// No match means we are unable to handle a legitimate command.
-	logger.Fatalf("magicbus: no aggregate handler was interested in %s", cmd)
+	if !ok {
+		return nil, fault.New(fault.NotFound, "no handler for %s command to %s", cmd, cmd.Dest())
+	}
+	return ag, nil
 }
 
 // eventHandler is called my m.actor for each incoming event
 func (m *MagicBus) eventHandler(e event.Event) {
+	// 0. Events are persisted before dispatching them, unless already stored by their Aggregate.
+	if se, ok := e.(storedEvent); ok {
+		e = se.Event
+	} else if err := m.persist(e); err != nil {
+		logger.Errorf("magicbus: failed to persist %v: %s", e, err)
+		m.deadLetterEvent(e, fmt.Sprintf("failed to persist event: %s", err))
+		return
+	}
+	m.recordEvent(e)
+
+	span := m.startEventSpan(e, "dispatch")
+	defer span.End()
+
 	// 1. Aggregates receive all events directed to them.
-	if ag, ok := m.aggregates[e.Dest()]; ok {
+	ag, consumed, err := m.lookup(e.Dest())
+	if err != nil {
+		logger.Errorf("magicbus: unable to deliver %v: %s", e, err)
+	} else if consumed {
+		m.handoff(ag)
+		span.SetAttribute("aggregate", ag.AggregateID().String())
 		if err := ag.Publish(e); err != nil {
 			logger.Warningf("%s: failed to publish %v: %s", ag.AggregateID(), e, err)
 		}
 	}
 
-	// 2. Observers are handled in parallel.
-	for _, handler := range m.observers {
-		eventHandler := handler // avoid loop variable alias
-		go eventHandler(e)
+	// 2. Subscribers whose filters match receive the event via their delivery queues.
+	var subscribers int
+	for key, sub := range m.subscriptions {
+		if sub.matches(e) {
+			consumed = true
+			subscribers++
+			if last := sub.dispatch(e); last {
+				m.endSubscription(key, sub, false)
+			}
+		}
+	}
+
+	span.SetAttribute("subscribers", strconv.Itoa(subscribers))
+
+	// A CommandDone nobody waits for is normal (fire-and-forget Submit).
+	if _, isCmdDone := e.(*event.CommandDone); !consumed && !isCmdDone {
+		m.deadLetterEvent(e, "no aggregate or subscriber to consume the event")
 	}
 }
 
@@ -75,7 +218,7 @@ func (m *MagicBus) String() string {
 	var resChan = make(chan string, 1)
 
 	if err := <-m.Action(func() error {
-		resChan <- fmt.Sprintf("bus (aggregates: %d, subscriptions: %d)", len(m.aggregates), len(m.observers))
+		resChan <- fmt.Sprintf("bus (aggregates: %d, subscriptions: %d)", len(m.aggregates), len(m.subscriptions))
 		return nil
 	}); err != nil {
 		return fmt.Sprintf("bus in error: %s", err)
@@ -83,31 +226,77 @@ func (m *MagicBus) String() string {
 	return <-resChan
 }
 
+// QueueDepth returns the number of commands and events queued for Aggregate @id.
+func (m *MagicBus) QueueDepth(id aggregate.ID) (commands, events int, err error) {
+	err = <-m.Action(func() error {
+		ag, ok := m.aggregates[id]
+		if !ok {
+			return fault.New(fault.NotFound, "no aggregate %s registered", id)
+		}
+		commands, events = ag.QueueDepth()
+		return nil
+	})
+	return commands, events, err
+}
+
 // Register registers @a to handle commands on the local bus.
 // @ready: whether the aggregate is ready to process commands right away
-func (m *MagicBus) Register(a aggregate.Aggregate, ready bool) error {
+// @opts:  optional settings, e.g. supervision
+func (m *MagicBus) Register(a aggregate.Aggregate, ready bool, opts ...RegisterOption) error {
+	var reg registration
+
+	for _, opt := range opts {
+		opt(&reg)
+	}
+
 	if a == nil {
-		return errors.Errorf("attempt to register a nil Aggregate")
+		return fault.New(fault.Rejected, "attempt to register a nil Aggregate")
 	} else if a.AggregateID().IsZero() {
-		return errors.Errorf("attempt to register an Aggregate with an empty AggregateID")
+		return fault.New(fault.Rejected, "attempt to register an Aggregate with an empty AggregateID")
+	} else if reg.idleTimeout > 0 && reg.factory == nil && reg.sourcedFactory == nil {
+		return fault.New(fault.Rejected, "%s: passivation requires a factory to reactivate the aggregate", a.AggregateID())
 	}
 
 	return <-m.Action(func() error {
-		logger.Debugf("magicbus: registering %s", a.AggregateID())
-
 		// Allow duplicate registration for robustness, reusing the first one.
 		if _, exists := m.aggregates[a.AggregateID()]; !exists {
-			m.aggregates[a.AggregateID()] = newAggregateActor(m.Context(), a, ready)
+			m.passivated.remove(a.AggregateID())
+			m.register(a, ready, reg)
 		}
 		return nil
 	})
 }
 
+// register starts an aggregateActor for @a. Must be called from within the actor loop of @m.
+func (m *MagicBus) register(a aggregate.Aggregate, ready bool, reg registration) *aggregateActor {
+	logger.Debugf("magicbus: registering %s", a.AggregateID())
+
+	ag := newAggregateActor(m, a, ready, reg)
+	m.aggregates[a.AggregateID()] = ag
+	m.startIdleTimer(ag)
+	return ag
+}
+
+// escalated removes @ag after it escalated @failure, so that further commands to it are dead-lettered.
+func (m *MagicBus) escalated(ag *aggregateActor, failure actor.Failure) {
+	logger.Errorf("magicbus: %s escalated %s - unregistering", ag.AggregateID(), failure.Err)
+
+	if err := <-m.Action(func() error {
+		if m.aggregates[ag.AggregateID()] == ag { // it may have been re-registered in the meantime
+			delete(m.aggregates, ag.AggregateID())
+		}
+		return nil
+	}); err != nil {
+		logger.Errorf("magicbus: failed to unregister %s: %s", ag.AggregateID(), err)
+	}
+}
+
 // Unregister removes @a from the bus
 func (m *MagicBus) Unregister(id aggregate.ID) error {
 	return <-m.Action(func() error {
 		logger.Debugf("magicbus: de-registering %s", id)
 
+		m.passivated.remove(id)
 		ag, ok := m.aggregates[id]
 		if !ok {
 			return nil
@@ -11,7 +11,8 @@ import (
 	"github.com/grrtrr/magicbus/aggregate"
 	"github.com/grrtrr/magicbus/command"
 	"github.com/grrtrr/magicbus/event"
-	"github.com/pkg/errors"
+	"github.com/grrtrr/magicbus/fault"
+	"github.com/grrtrr/magicbus/trace"
 )
 
 // GLOBAL VARIABLES
@@ -20,82 +21,182 @@ var (
 	logger   = logrus.WithField("module", "magicbus")
 )
 
-// Init allocates the %localBus.
-func Init(ctx context.Context) {
-	localBus = NewMagicBus(ctx)
+// Init allocates the %localBus, configured by @opts.
+func Init(ctx context.Context, opts ...Option) {
+	localBus = NewMagicBus(ctx, opts...)
 }
 
-// Launch takes command @data, turns it into a Command, and submits it to the local bus.
-// The result of the command (via the CommandDone event) is reported via the error channel.
+// Launch submits @cmd to the local bus, and waits for its result (see MagicBus.Launch).
 func Launch(ctx context.Context, cmd *aggregate.Command) command.Result {
-	var resultCh = make(chan command.Result, 1)
+	return localBus.Launch(ctx, cmd)
+}
+
+// LaunchWait is a variation of Launch which takes a timeout @maxWait instead of a context.
+func LaunchWait(cmd *aggregate.Command, maxWait time.Duration) command.Result {
+	return localBus.LaunchWait(cmd, maxWait)
+}
+
+// LaunchTyped is Launch on the local bus for commands whose result is of type T (see command.ResultAs).
+func LaunchTyped[T any](ctx context.Context, cmd *aggregate.Command) (T, error) {
+	return command.ResultAs[T](localBus.Launch(ctx, cmd))
+}
+
+// Submit @cmd to the local bus or forward it to a remote bus.
+func Submit(ctx context.Context, cmd *aggregate.Command) error {
+	return localBus.submit(ctx, cmd)
+}
+
+// Publish @evt on the local bus, or pass it on to the remote bus of evt.Dest().
+func Publish(evt event.Event) {
+	if err := localBus.Publish(evt); err != nil && err != actor.ErrShutdown {
+		logger.Errorf("failed to publish event %v: %s", evt, err)
+	}
+}
+
+// RegisterAggregate registers @a to handle commands on the local bus.
+// @ready: whether the aggregate is ready to process commands right away
+// @opts:  optional settings, e.g. supervision
+func RegisterAggregate(a aggregate.Aggregate, ready bool, opts ...RegisterOption) {
+	if err := localBus.Register(a, ready, opts...); err != nil {
+		logger.Fatalf("%s: registration failed: %s", a.AggregateID(), err)
+	}
+}
+
+// UnregisterAggregate removes Aggregate @id from the bus.
+func UnregisterAggregate(id aggregate.ID) {
+	if err := localBus.Unregister(id); err != nil {
+		logger.Fatalf("%s: de-registration failed: %s", id, err)
+	}
+}
 
-	id, err := Observer( // Perform a one-off subscription for the CommandDone event.
+// Launch takes command @data, turns it into a Command, and submits it to @m.
+// The result of the command (via the CommandDone event) is reported via the error channel.
+// The CommandDone event is matched via the command ID, so that concurrent Launches do not interfere.
+// If the command has follow-up commands (see aggregate.NewFanOut), Launch returns after all of them
+// have completed, too, and reports their results as branches of the result.
+func (m *MagicBus) Launch(ctx context.Context, cmd *aggregate.Command) command.Result {
+	return m.await(ctx, cmd).Result()
+}
+
+// await submits @cmd to @m and waits for its CommandDone event (see Launch).
+// Failure to obtain the CommandDone event is reported as a CommandDone with an error.
+func (m *MagicBus) await(ctx context.Context, cmd *aggregate.Command) *event.CommandDone {
+	var doneCh = make(chan *event.CommandDone, 1)
+	var span = m.startCommandSpan(submitParent(ctx, cmd), cmd, "launch")
+	var failed = func(err error) *event.CommandDone {
+		span.SetError(err)
+		return event.NewCmdDone(cmd.Dest(), cmd, nil, err).(*event.CommandDone)
+	}
+	defer span.End()
+
+	// The submit span of @cmd is a child of the launch span.
+	if span.Context().IsValid() {
+		ctx = trace.ContextWithSpan(ctx, span.Context())
+	}
+
+	// The subscription is reclaimed by the bus as soon as await returns.
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	_, err := m.Subscribe( // Perform a one-off subscription for the CommandDone event.
 		func(e event.Event) {
-			if cd, ok := e.(*event.CommandDone); ok && e.Dest() == cmd.Source() {
-				resultCh <- cd.Result()
+			select {
+			case doneCh <- e.(*event.CommandDone):
+			default: // duplicate delivery
 			}
 		},
+		ByEventType(&event.CommandDone{}),
+		Where(func(e event.Event) bool { return e.(*event.CommandDone).CmdID == cmd.ID() }),
+		Once(),
+		UntilDone(subCtx),
 	)
 	if err != nil {
-		return command.Result{Err: errors.Errorf("failed to subscribe to %s CommandDone event: %s", cmd.Type(), err)}
+		return failed(fault.Wrapf(err, fault.Internal, "failed to subscribe to %s CommandDone event", cmd.Type()))
 	}
-	defer Unsubscribe(id)
 
-	if err = Submit(ctx, cmd); err != nil {
-		return command.Result{Err: errors.Errorf("failed to submit %s: %s", cmd.Type(), err)}
+	if err = m.submit(ctx, cmd); err != nil {
+		return failed(fault.Wrapf(err, fault.Unavailable, "failed to submit %s", cmd.Type()))
 	}
 
+	var cd *event.CommandDone
 	select {
-	case ret := <-resultCh:
-		return ret
+	case cd = <-doneCh:
 	case <-ctx.Done():
 		if ctx.Err() == context.DeadlineExceeded {
-			return command.Result{Err: errors.Errorf("timed out waiting for %s to complete", cmd.Type())}
+			return failed(fault.New(fault.Timeout, "timed out waiting for %s to complete", cmd.Type()))
 		}
-		return command.Result{Err: ctx.Err()}
+		return failed(fault.Wrap(ctx.Err(), fault.Canceled))
 	case <-cmd.Context().Done():
-		return command.Result{Err: errors.Errorf("command %s canceled: %s", cmd.Type(), cmd.Context().Err())}
+		return failed(fault.Wrapf(cmd.Context().Err(), fault.Canceled, "command %s canceled", cmd.Type()))
 	}
+	span.SetError(cd.Result().Err)
+	return cd
 }
 
 // LaunchWait is a variation of Launch which takes a timeout @maxWait instead of a context.
-func LaunchWait(cmd *aggregate.Command, maxWait time.Duration) command.Result {
-	ctx, _ := context.WithTimeout(cmd.Context(), maxWait)
-	return Launch(ctx, cmd)
+func (m *MagicBus) LaunchWait(cmd *aggregate.Command, maxWait time.Duration) command.Result {
+	ctx, cancel := context.WithTimeout(cmd.Context(), maxWait)
+	defer cancel()
+	return m.Launch(ctx, cmd)
 }
 
-// Submit @cmd to the local bus or forward it to a remote bus.
-func Submit(ctx context.Context, cmd *aggregate.Command) error {
-	if !cmd.Dest().IsLocal() {
-		return remoteSubmit(ctx, cmd)
+// Submit @cmd to @m, or forward it to the remote bus of cmd.Dest().
+func (m *MagicBus) Submit(cmd *aggregate.Command) error {
+	if cmd == nil {
+		return fault.New(fault.Rejected, "attempt to submit a nil command")
 	}
-	return localBus.Submit(cmd)
+	return m.submit(cmd.Context(), cmd)
 }
 
-// Publish @evt on the local bus, or pass it on to shcomm as STATUS message.
-func Publish(evt event.Event) {
-	if err := func() error {
-		if !evt.Dest().IsZero() && !evt.Dest().IsLocal() {
-			return remotePublish(localBus.Context(), evt)
-		}
-		return localBus.Publish(evt)
-	}(); err != nil && err != actor.ErrShutdown {
-		logger.Errorf("%s: failed to publish event (%s): %s", evt, err)
+// submit is Submit with context @ctx for sending @cmd to a remote bus.
+// If tracing is enabled, @cmd carries the submit span to its handler (see trace.Inject).
+func (m *MagicBus) submit(ctx context.Context, cmd *aggregate.Command) (err error) {
+	if cmd == nil {
+		return fault.New(fault.Rejected, "attempt to submit a nil command")
 	}
+
+	span := m.startCommandSpan(submitParent(ctx, cmd), cmd, "submit")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	if span.Context().IsValid() {
+		cmd.WithMetadata(trace.Inject(span.Context()))
+	}
+
+	if !cmd.Dest().IsLocal() {
+		span.SetAttribute("remote", "true")
+		return m.remoteSubmit(ctx, cmd)
+	}
+	return m.Actor.Submit(cmd)
 }
 
-// RegisterAggregate registers @a to handle commands on the local bus.
-// @ready: whether the aggregate is ready to process commands right away
-func RegisterAggregate(a aggregate.Aggregate, ready bool) {
-	if err := localBus.Register(a, ready); err != nil {
-		logger.Fatalf("%s: registration failed: %s", a.AggregateID(), err)
+// Publish @evt on @m, or pass it on to the remote bus of evt.Dest().
+// The publish interceptors of @m (see WithPublishInterceptors) apply.
+// Events of an Aggregate which is handling a command inherit the metadata of that command.
+func (m *MagicBus) Publish(evt event.Event) error {
+	if evt == nil {
+		return fault.New(fault.Rejected, "attempt to publish a nil event")
+	} else if cmd, ok := m.handling.Load(evt.Source()); ok {
+		event.Propagate(evt, cmd.(*aggregate.Command).CausedMetadata())
 	}
+	return chainPublish(m.publishInterceptors, m.publish)(evt)
 }
 
-// UnregisterAggregate removes Aggregate @id from the bus.
-func UnregisterAggregate(id aggregate.ID) {
-	if err := localBus.Unregister(id); err != nil {
-		logger.Fatalf("%s: de-registration failed: %s", id, err)
+// publishStored is Publish for @evt, which has already been persisted by its Aggregate.
+func (m *MagicBus) publishStored(evt event.Event) error {
+	return chainPublish(m.publishInterceptors, func(e event.Event) error {
+		return m.publish(storedEvent{e})
+	})(evt)
+}
+
+// publish is Publish without interceptors.
+func (m *MagicBus) publish(evt event.Event) error {
+	if !evt.Dest().IsZero() && !evt.Dest().IsLocal() {
+		if se, ok := evt.(storedEvent); ok {
+			evt = se.Event
+		}
+		return m.remotePublish(m.Context(), evt)
 	}
+	return m.Actor.Publish(evt)
 }
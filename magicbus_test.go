@@ -3,11 +3,15 @@ package magicbus
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/grrtrr/magicbus/actor"
 	"github.com/grrtrr/magicbus/aggregate"
 	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
 	"github.com/pkg/errors"
 )
 
@@ -73,10 +77,12 @@ func TestNewMagicBus(t *testing.T) {
 	te := mkTestEvent(a.AggregateID(), a.AggregateID(), "Test event")
 
 	// 1. One-off subscription: multiple events result in only 1 handler call
+	var calls int32
 	teHdlr := func(e event.Event) {
+		atomic.AddInt32(&calls, 1)
 		t.Logf("first event handler received %s", e)
 	}
-	id, err := m.observer(teHdlr)
+	id, err := m.Subscribe(teHdlr, Once())
 	if err != nil {
 		t.Fatalf("failed to subscribe %s: %s", te, err)
 	}
@@ -86,7 +92,7 @@ func TestNewMagicBus(t *testing.T) {
 	teHdlr2 := func(e event.Event) {
 		t.Logf("second event handler received %s", e)
 	}
-	id1, err := m.observer(teHdlr2)
+	id1, err := m.Observer(teHdlr2)
 	if err != nil {
 		t.Fatalf("failed to subscribe %s: %s", te, err)
 	} else if id1 == id {
@@ -105,9 +111,13 @@ func TestNewMagicBus(t *testing.T) {
 	// Wait for handlers to settle and event to reach aggregate before unsubscription
 	time.Sleep(1 * time.Second)
 
-	if err := m.unsubscribe(id); err != nil {
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("one-off subscription handler called %d times", n)
+	}
+
+	if err := m.Unsubscribe(id); err != nil {
 		t.Fatalf("failed to unsubscribe first event handler: %s", err)
-	} else if err := m.unsubscribe(id1); err != nil {
+	} else if err := m.Unsubscribe(id1); err != nil {
 		t.Fatalf("failed to unsubscribe second event handler: %s", err)
 	}
 
@@ -170,3 +180,264 @@ func (t *testAggregate) HandleCommand(cmd *aggregate.Command) (*aggregate.Comman
 	// NB: CommandDone will be published by aggregate_actor anyway
 	return nil, nil, nil
 }
+
+func TestParallelLaunch(t *testing.T) {
+	const numLaunches = 500
+	var wg sync.WaitGroup
+	var a = &echoAggregate{id: aggregate.NewID(aggregate.ResourceType_MEMORY, "parallel")}
+
+	RegisterAggregate(a, true)
+	defer UnregisterAggregate(a.AggregateID())
+
+	// All commands share the same source aggregate: each Launch must get its own result.
+	for i := 0; i < numLaunches; i++ {
+		wg.Add(1)
+		go func(seq int) {
+			defer wg.Done()
+
+			cmd, err := aggregate.NewCommand(a.AggregateID(), a.AggregateID(), seqCommand{Seq: seq})
+			if err != nil {
+				t.Errorf("failed to create command #%d: %s", seq, err)
+				return
+			}
+
+			res := LaunchWait(cmd, 10*time.Second)
+			if res.Err != nil {
+				t.Errorf("launch #%d failed: %s", seq, res.Err)
+			} else if res.Result != seq {
+				t.Errorf("launch #%d received result of %v", seq, res.Result)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// Test command carrying a sequence number
+type seqCommand struct {
+	Seq int
+}
+
+// echoAggregate returns the sequence number of each seqCommand as result
+type echoAggregate struct {
+	id aggregate.ID
+}
+
+func (e *echoAggregate) AggregateID() aggregate.ID {
+	return e.id
+}
+
+func (e *echoAggregate) HandleCommand(cmd *aggregate.Command) (*aggregate.Command, interface{}, error) {
+	if sc, ok := cmd.Data().(seqCommand); ok {
+		return nil, sc.Seq, nil
+	}
+	return nil, nil, errors.Errorf("unexpected command %s", cmd)
+}
+
+func TestAggregateFactory(t *testing.T) {
+	var created = make(chan aggregate.ID, 10)
+
+	RegisterFactory(aggregate.ResourceType_MEMORY, func(id aggregate.ID) (aggregate.Aggregate, error) {
+		if id.ID == "broken" {
+			return nil, errors.Errorf("unable to create %s", id)
+		} else if id.ID == "typed-nil" {
+			return (*echoAggregate)(nil), nil
+		}
+		created <- id
+		return &echoAggregate{id: id}, nil
+	})
+	defer UnregisterFactory(aggregate.ResourceType_MEMORY)
+
+	// Each entity is created once, on its first command.
+	for i, name := range []string{"bank1", "bank2", "bank1"} {
+		id := aggregate.NewID(aggregate.ResourceType_MEMORY, name)
+		defer UnregisterAggregate(id)
+
+		cmd, _ := aggregate.NewLocalCommand(id, seqCommand{Seq: i})
+		if res := LaunchWait(cmd, time.Second); res.Err != nil {
+			t.Fatalf("%s failed: %s", cmd, res.Err)
+		} else if res.Result != i {
+			t.Fatalf("%s: unexpected result %v", cmd, res.Result)
+		}
+	}
+
+	if len(created) != 2 {
+		t.Fatalf("expected 2 aggregates to be created, got %d", len(created))
+	} else if id := <-created; id.ID != "bank1" {
+		t.Fatalf("unexpected first aggregate %s", id)
+	}
+
+	// Factory errors, and nil aggregates, fail the command.
+	for _, name := range []string{"broken", "typed-nil"} {
+		cmd, _ := aggregate.NewLocalCommand(aggregate.NewID(aggregate.ResourceType_MEMORY, name), seqCommand{})
+		if res := LaunchWait(cmd, time.Second); res.Err == nil {
+			t.Fatalf("expected %s to fail, but got %s", cmd, res)
+		} else if code := fault.CodeOf(res.Err); code != fault.Internal {
+			t.Fatalf("%s: expected %s error, got %s (%s)", cmd, fault.Internal, code, res.Err)
+		}
+	}
+}
+
+func TestPassivation(t *testing.T) {
+	var created int32
+	var id = aggregate.NewID(aggregate.ResourceType_CPU, "counter")
+
+	RegisterFactory(aggregate.ResourceType_CPU, func(id aggregate.ID) (aggregate.Aggregate, error) {
+		atomic.AddInt32(&created, 1)
+		return &counterAggregate{id: id}, nil
+	}, WithPassivation(50*time.Millisecond))
+	defer UnregisterFactory(aggregate.ResourceType_CPU)
+	defer UnregisterAggregate(id)
+
+	increment := func(expected int) {
+		cmd, _ := aggregate.NewLocalCommand(id, "increment")
+		if res := LaunchWait(cmd, time.Second); res.Err != nil {
+			t.Fatalf("%s failed: %s", cmd, res.Err)
+		} else if res.Result != expected {
+			t.Fatalf("expected count %d, got %v", expected, res.Result)
+		}
+	}
+	increment(1)
+
+	// Wait for the idle aggregate to be passivated.
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, _, err := localBus.QueueDepth(id); err != nil {
+			break
+		} else if time.Since(start) > time.Second {
+			t.Fatalf("%s was not passivated", id)
+		}
+	}
+
+	// The next command reactivates it, with its state restored from the snapshot.
+	increment(2)
+	if n := atomic.LoadInt32(&created); n != 2 {
+		t.Fatalf("expected the aggregate to be created twice, got %d", n)
+	}
+}
+
+func TestPassivationCapacity(t *testing.T) {
+	var ids = []aggregate.ID{aggregate.NewID(aggregate.ResourceType_CPU, "first"), aggregate.NewID(aggregate.ResourceType_CPU, "second")}
+
+	m := NewMagicBus(context.Background(), WithPassivationCapacity(1))
+	defer m.Shutdown()
+
+	if err := m.RegisterFactory(aggregate.ResourceType_CPU, func(id aggregate.ID) (aggregate.Aggregate, error) {
+		return &counterAggregate{id: id}, nil
+	}, WithPassivation(20*time.Millisecond)); err != nil {
+		t.Fatalf("failed to register factory: %s", err)
+	}
+
+	increment := func(id aggregate.ID, expected int) {
+		cmd, _ := aggregate.NewLocalCommand(id, "increment")
+		if res := m.LaunchWait(cmd, time.Second); res.Err != nil {
+			t.Fatalf("%s failed: %s", cmd, res.Err)
+		} else if res.Result != expected {
+			t.Fatalf("expected count %d of %s, got %v", expected, id, res.Result)
+		}
+	}
+
+	// Passivate one aggregate after the other, so that the first one is evicted.
+	for _, id := range ids {
+		increment(id, 1)
+		for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+			if _, _, err := m.QueueDepth(id); err != nil {
+				break
+			} else if time.Since(start) > time.Second {
+				t.Fatalf("%s was not passivated", id)
+			}
+		}
+	}
+
+	// The evicted aggregate starts afresh, the retained one is restored from its snapshot.
+	increment(ids[0], 1)
+	increment(ids[1], 2)
+}
+
+// counterAggregate counts the commands it has received, and supports snapshots.
+type counterAggregate struct {
+	id    aggregate.ID
+	count int
+}
+
+func (c *counterAggregate) AggregateID() aggregate.ID {
+	return c.id
+}
+
+func (c *counterAggregate) HandleCommand(cmd *aggregate.Command) (*aggregate.Command, interface{}, error) {
+	c.count++
+	return nil, c.count, nil
+}
+
+func (c *counterAggregate) Snapshot() (interface{}, error) {
+	return c.count, nil
+}
+
+func (c *counterAggregate) Restore(snapshot interface{}) error {
+	c.count = snapshot.(int)
+	return nil
+}
+
+func TestIndependentBuses(t *testing.T) {
+	var id = aggregate.NewID(aggregate.ResourceType_CPU, "independent")
+	var buses = []*MagicBus{NewMagicBus(context.Background()), NewMagicBus(context.Background())}
+
+	for _, m := range buses {
+		defer m.Shutdown()
+
+		if err := m.Register(&counterAggregate{id: id}, true); err != nil {
+			t.Fatalf("failed to register %s: %s", id, err)
+		}
+	}
+
+	// Each bus routes commands and results only to its own instance of the aggregate.
+	for i, m := range append(buses, buses[0]) {
+		cmd, _ := aggregate.NewLocalCommand(id, "increment")
+		if res := m.LaunchWait(cmd, time.Second); res.Err != nil {
+			t.Fatalf("%s failed on bus #%d: %s", cmd, i, res.Err)
+		} else if expected := 1 + i/2; res.Result != expected {
+			t.Fatalf("expected count %d on bus #%d, got %v", expected, i, res.Result)
+		}
+	}
+}
+
+func TestAggregatePanic(t *testing.T) {
+	var instances int
+	var id = aggregate.NewID(aggregate.ResourceType_CPU, "panicky")
+
+	factory := func(id aggregate.ID) (aggregate.Aggregate, error) {
+		instances++
+		return &panickyAggregate{id: id, instance: instances}, nil
+	}
+	a, _ := factory(id)
+
+	RegisterAggregate(a, true, WithSupervision(actor.Restart), WithFactory(factory))
+	defer UnregisterAggregate(id)
+
+	// The panic is reported as failed command
+	if res := LaunchWait(mkTestCommand(id, "panic"), time.Second); res.Err == nil {
+		t.Fatalf("expected panicking command to fail, but got %s", res)
+	}
+
+	// The aggregate has been replaced by a new instance from the factory.
+	if res := LaunchWait(mkTestCommand(id, "instance"), time.Second); res.Err != nil {
+		t.Fatalf("aggregate did not survive panic: %s", res.Err)
+	} else if res.Result != 2 {
+		t.Fatalf("aggregate was not restarted: instance %v", res.Result)
+	}
+}
+
+// panickyAggregate panics when receiving a "panic" command.
+type panickyAggregate struct {
+	id       aggregate.ID
+	instance int
+}
+
+func (p *panickyAggregate) AggregateID() aggregate.ID {
+	return p.id
+}
+
+func (p *panickyAggregate) HandleCommand(cmd *aggregate.Command) (*aggregate.Command, interface{}, error) {
+	if cmd.Type() == "panic" {
+		panic("panickyAggregate failed")
+	}
+	return nil, p.instance, nil
+}
@@ -0,0 +1,85 @@
+package magicbus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+)
+
+func TestMetadataPropagation(t *testing.T) {
+	var cpus = &pool{id: aggregate.NewID(aggregate.ResourceType_CPU, "metadata"), capacity: 8}
+	var memory = &pool{id: aggregate.NewID(aggregate.ResourceType_MEMORY, "metadata"), capacity: 1024}
+	var planner = &plannerAggregate{id: aggregate.NewID(aggregate.ResourceType_CPU, "metaplanner"), cpus: cpus.id, memory: memory.id}
+	var bank = &memoryBank{id: aggregate.NewID(aggregate.ResourceType_MEMORY, "metabank")}
+
+	m := NewMagicBus(context.Background())
+	defer m.Shutdown()
+
+	var herald = &heraldAggregate{id: aggregate.NewID(aggregate.ResourceType_MEMORY, "herald"), bus: m}
+	for _, a := range []aggregate.Aggregate{cpus, memory, planner, herald} {
+		if err := m.Register(a, true); err != nil {
+			t.Fatalf("failed to register %s: %s", a.AggregateID(), err)
+		}
+	}
+	if err := m.RegisterEventSourced(bank, true); err != nil {
+		t.Fatalf("failed to register %s: %s", bank.id, err)
+	}
+
+	var events = make(chan event.Event, 10)
+	if _, err := m.Subscribe(func(e event.Event) { events <- e }); err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+
+	// expect checks that the next @n events carry the metadata @md.
+	expect := func(n int, md aggregate.Metadata) {
+		for i := 0; i < n; i++ {
+			select {
+			case e := <-events:
+				for k, v := range md {
+					if event.MetadataOf(e)[k] != v {
+						t.Fatalf("%v: expected %s = %q, got %v", e, k, v, event.MetadataOf(e))
+					}
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for event #%d", i+1)
+			}
+		}
+	}
+
+	// The CommandDone events of a fan-out and of all its branches carry the metadata of the command.
+	md := aggregate.Metadata{"user": "alice", "tenant": "acme"}
+	cmd, _ := aggregate.NewLocalCommand(planner.id, provision{CPUs: 1, Bytes: 1})
+	if res := m.LaunchWait(cmd.WithMetadata(md), time.Second); res.Err != nil {
+		t.Fatalf("%s failed: %s", cmd, res.Err)
+	}
+	expect(4, md) // 2 branches, join, and the command itself
+
+	// Events emitted by an event-sourced aggregate carry the metadata of the command.
+	cmd, _ = aggregate.NewLocalCommand(bank.id, allocate{Bytes: 1})
+	if res := m.LaunchWait(cmd.WithMetadata(md), time.Second); res.Err != nil {
+		t.Fatalf("%s failed: %s", cmd, res.Err)
+	}
+	expect(2, md) // allocated, CommandDone
+
+	// Events which a plain aggregate publishes while handling a command are stamped with its metadata.
+	cmd, _ = aggregate.NewLocalCommand(herald.id, "announce")
+	if res := m.LaunchWait(cmd.WithMetadata(md), time.Second); res.Err != nil {
+		t.Fatalf("%s failed: %s", cmd, res.Err)
+	}
+	expect(2, aggregate.Metadata{"user": "alice", aggregate.CausationKey: cmd.ID()}) // allocated, CommandDone
+}
+
+// heraldAggregate publishes an event for each command it handles.
+type heraldAggregate struct {
+	id  aggregate.ID
+	bus *MagicBus
+}
+
+func (h *heraldAggregate) AggregateID() aggregate.ID { return h.id }
+
+func (h *heraldAggregate) HandleCommand(cmd *aggregate.Command) (*aggregate.Command, interface{}, error) {
+	return nil, nil, h.bus.Publish(&allocated{Src: h.id, Bytes: 1})
+}
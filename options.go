@@ -0,0 +1,127 @@
+package magicbus
+
+import (
+	"time"
+
+	"github.com/grrtrr/magicbus/actor"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/eventstore"
+	"github.com/grrtrr/magicbus/schedule"
+	"github.com/grrtrr/magicbus/trace"
+	"github.com/grrtrr/magicbus/transport"
+)
+
+// Option configures a MagicBus at construction time.
+type Option func(*MagicBus)
+
+// WithTransport connects the bus to remote buses via @t.
+// Commands and events for aggregates on other nodes are sent via @t, and
+// commands and events received from @t are injected into the bus.
+func WithTransport(t transport.Transport) Option {
+	return func(m *MagicBus) {
+		m.transport = t
+	}
+}
+
+// WithDeadLetterCapacity sets the number of dead letters retained for inspection (0 disables retention).
+func WithDeadLetterCapacity(n int) Option {
+	return func(m *MagicBus) {
+		m.deadLetters.capacity = n
+	}
+}
+
+// WithPassivationCapacity bounds the number of passivated Aggregates retained for reactivation
+// to @n (0: unbounded). Beyond that, the least recently passivated Aggregates are evicted along with
+// their snapshots, so that the next command re-creates them via the factory of their type, if any.
+func WithPassivationCapacity(n int) Option {
+	return func(m *MagicBus) {
+		m.passivated.capacity = n
+	}
+}
+
+// WithMailbox bounds the command and event mailboxes of the bus itself to @size elements each.
+// Submit/Publish on a full bus then behave according to @policy.
+// NB: since the bus publishes CommandDone events from within its own loop, actor.Block
+// can deadlock a full bus - prefer actor.Reject or actor.DropOldest here.
+func WithMailbox(size int, policy actor.OverflowPolicy) Option {
+	return func(m *MagicBus) {
+		m.actorOptions = append(m.actorOptions, actor.WithMailbox(size, policy))
+	}
+}
+
+// WithHistory sets the source of past events, used to rebuild event-sourced Aggregates on registration.
+func WithHistory(h event.History) Option {
+	return func(m *MagicBus) {
+		m.history = h
+	}
+}
+
+// WithEventStore persists all events published on the bus in @s, before dispatching them.
+// Unless WithHistory is also given, @s serves as history of event-sourced Aggregates.
+func WithEventStore(s eventstore.Store) Option {
+	return func(m *MagicBus) {
+		m.store = s
+	}
+}
+
+// WithCommandInterceptors adds @interceptors to the handling of all commands on the bus.
+// They run before the interceptors of the individual Aggregate (see WithAggregateInterceptors).
+func WithCommandInterceptors(interceptors ...CommandInterceptor) Option {
+	return func(m *MagicBus) {
+		m.commandInterceptors = append(m.commandInterceptors, interceptors...)
+	}
+}
+
+// WithPublishInterceptors adds @interceptors to the publishing of events on the bus.
+func WithPublishInterceptors(interceptors ...PublishInterceptor) Option {
+	return func(m *MagicBus) {
+		m.publishInterceptors = append(m.publishInterceptors, interceptors...)
+	}
+}
+
+// WithDeliverInterceptors adds @interceptors to the delivery of events to Aggregates and subscribers.
+// They run before the interceptors of the individual Aggregate (see WithAggregateDeliverInterceptors).
+func WithDeliverInterceptors(interceptors ...DeliverInterceptor) Option {
+	return func(m *MagicBus) {
+		m.deliverInterceptors = append(m.deliverInterceptors, interceptors...)
+	}
+}
+
+// WithRetryPolicy makes the bus retry failed commands of type @cmdType (see Command.Type) according to @p.
+func WithRetryPolicy(cmdType string, p RetryPolicy) Option {
+	return func(m *MagicBus) {
+		m.retries.policies[cmdType] = p
+	}
+}
+
+// WithFollowUpTimeout bounds the time which the next step of a command (see Aggregate.HandleCommand)
+// may take to complete, unless the context of the command has a deadline of its own.
+func WithFollowUpTimeout(d time.Duration) Option {
+	return func(m *MagicBus) {
+		m.followUpTimeout = d
+	}
+}
+
+// WithScheduleStore persists scheduled command deliveries (see SubmitAt) in @s, so that they survive
+// a restart of the bus. Pending schedules are restored from @s when the bus starts.
+func WithScheduleStore(s schedule.Store) Option {
+	return func(m *MagicBus) {
+		m.scheduleStore = s
+	}
+}
+
+// WithRecorder records the causal history of the commands and events on the bus in @r (see Recorder.Tree).
+func WithRecorder(r *Recorder) Option {
+	return func(m *MagicBus) {
+		m.recorder = r
+	}
+}
+
+// WithTracer records the spans of the commands and events on the bus with @t (see trace.Tracer).
+// Spans started outside the bus become parents of the spans of a command when passed to Launch,
+// or attached to the command via trace.ContextWithSpan.
+func WithTracer(t trace.Tracer) Option {
+	return func(m *MagicBus) {
+		m.tracer = t
+	}
+}
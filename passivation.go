@@ -0,0 +1,173 @@
+package magicbus
+
+import (
+	"container/list"
+	"sync/atomic"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// Default number of passivated Aggregates retained for reactivation
+const defaultPassivationCapacity = 10000
+
+// errBusy aborts a passivation attempt, since the Aggregate has received messages in the meantime.
+var errBusy = fault.New(fault.Unavailable, "aggregate busy")
+
+// passivated retains what is needed to reactivate a passivated Aggregate.
+type passivated struct {
+	// Registration settings of the Aggregate, including its factory
+	registration
+
+	// Saved state of the Aggregate (nil if not an aggregate.Snapshotter)
+	snapshot interface{}
+
+	// Position in the order of passivation
+	elem *list.Element
+}
+
+// passivation retains passivated Aggregates up to a capacity, evicting the least recently passivated first.
+type passivation struct {
+	// Maximum number of retained Aggregates (<= 0: unbounded)
+	capacity int
+
+	// Retained Aggregates (map { AggregateID -> passivated })
+	retained map[aggregate.ID]*passivated
+
+	// IDs of the retained Aggregates, least recently passivated first
+	order *list.List
+}
+
+func newPassivation(capacity int) *passivation {
+	return &passivation{capacity: capacity, retained: map[aggregate.ID]*passivated{}, order: list.New()}
+}
+
+// add retains @p as @id, evicting the least recently passivated Aggregates beyond the capacity.
+// Evicted Aggregates lose their snapshot, and can only be re-created by the factory of their type.
+func (ps *passivation) add(id aggregate.ID, p *passivated) {
+	ps.remove(id)
+	p.elem = ps.order.PushBack(id)
+	ps.retained[id] = p
+
+	for ps.capacity > 0 && ps.order.Len() > ps.capacity {
+		evicted := ps.order.Front().Value.(aggregate.ID)
+		logger.Warningf("magicbus: evicting passivated %s - %d passivated aggregates retained", evicted, ps.capacity)
+		ps.remove(evicted)
+	}
+}
+
+// get returns the Aggregate retained as @id, if any.
+func (ps *passivation) get(id aggregate.ID) (p *passivated, ok bool) {
+	p, ok = ps.retained[id]
+	return p, ok
+}
+
+// remove stops retaining @id.
+func (ps *passivation) remove(id aggregate.ID) {
+	if p, ok := ps.retained[id]; ok {
+		ps.order.Remove(p.elem)
+		delete(ps.retained, id)
+	}
+}
+
+// handoff records that @m is about to pass a command or event on to @ag.
+// Must be called from within the actor loop of @m.
+func (m *MagicBus) handoff(ag *aggregateActor) {
+	atomic.StoreInt64(&ag.lastActive, time.Now().UnixNano())
+	atomic.AddUint64(&ag.handoffs, 1)
+}
+
+// startIdleTimer enables passivation of @ag, if configured.
+func (m *MagicBus) startIdleTimer(ag *aggregateActor) {
+	if ag.idleTimeout > 0 {
+		atomic.StoreInt64(&ag.lastActive, time.Now().UnixNano())
+		m.armIdleTimer(ag, ag.idleTimeout)
+	}
+}
+
+// armIdleTimer checks @ag for passivation after @d, repeating until @ag has been passivated or shut down.
+func (m *MagicBus) armIdleTimer(ag *aggregateActor, d time.Duration) {
+	time.AfterFunc(d, func() {
+		if next := m.checkIdle(ag); next > 0 {
+			m.armIdleTimer(ag, next)
+		}
+	})
+}
+
+// checkIdle passivates @ag if it has been idle for its idle timeout.
+// Otherwise returns the time until the next check (0 if @ag is no longer active).
+func (m *MagicBus) checkIdle(ag *aggregateActor) time.Duration {
+	var snapshot interface{}
+
+	if idle := time.Since(time.Unix(0, atomic.LoadInt64(&ag.lastActive))); idle < ag.idleTimeout {
+		return ag.idleTimeout - idle
+	}
+
+	// 1. Take the snapshot from within the loop of @ag, once it has processed all of its messages.
+	handoffs := atomic.LoadUint64(&ag.handoffs)
+	err := <-ag.Action(func() (err error) {
+		if commands, events := ag.QueueDepth(); commands+events > 0 {
+			return errBusy
+		} else if s, ok := ag.Aggregate.(aggregate.Snapshotter); ok {
+			snapshot, err = s.Snapshot()
+		}
+		return err
+	})
+
+	// 2. Replace @ag by its snapshot, unless the bus has handed off further messages in the meantime.
+	if err == nil {
+		err = <-m.Action(func() error {
+			if m.aggregates[ag.id] != ag { // unregistered or escalated in the meantime
+				return nil
+			} else if atomic.LoadUint64(&ag.handoffs) != handoffs {
+				return errBusy
+			}
+			delete(m.aggregates, ag.id)
+			m.passivated.add(ag.id, &passivated{registration: ag.registration, snapshot: snapshot})
+
+			logger.Debugf("magicbus: passivating %s after %s", ag.id, ag.idleTimeout)
+			return ag.Shutdown()
+		})
+	}
+
+	if err == errBusy {
+		return ag.idleTimeout
+	} else if err != nil && m.IsActive() && ag.IsActive() {
+		logger.Errorf("magicbus: failed to passivate %s: %s", ag.id, err)
+		return ag.idleTimeout
+	}
+	return 0
+}
+
+// lookup returns the aggregateActor registered as @id, reactivating it if passivated.
+// Must be called from within the actor loop of @m.
+func (m *MagicBus) lookup(id aggregate.ID) (ag *aggregateActor, ok bool, err error) {
+	if ag, ok = m.aggregates[id]; ok {
+		return ag, true, nil
+	} else if p, isPassivated := m.passivated.get(id); isPassivated {
+		ag, err = m.reactivate(id, p)
+		return ag, err == nil, err
+	}
+	return nil, false, nil
+}
+
+// reactivate re-creates the passivated Aggregate @id. Must be called from within the actor loop of @m.
+func (m *MagicBus) reactivate(id aggregate.ID, p *passivated) (*aggregateActor, error) {
+	a, err := m.create(id, p.registration)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.snapshot != nil {
+		if s, ok := a.(aggregate.Snapshotter); !ok {
+			return nil, fault.New(fault.Internal, "%s: unable to restore snapshot of %T", id, a)
+		} else if err = s.Restore(p.snapshot); err != nil {
+			return nil, fault.Wrapf(err, fault.Internal, "%s: failed to restore snapshot", id)
+		}
+	}
+
+	logger.Debugf("magicbus: reactivating %s", id)
+	m.passivated.remove(id)
+	return m.register(a, true, p.registration), nil
+}
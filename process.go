@@ -0,0 +1,232 @@
+package magicbus
+
+import (
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// ProcessManager coordinates workflows (sagas) which span several Aggregates.
+// Each workflow instance is a Process, identified by a correlation ID.
+type ProcessManager interface {
+	// Correlate returns the correlation ID of the Process which @e belongs to ("": none).
+	// The CommandDone events of the steps taken by a Process are routed to it without Correlate.
+	// The bus also calls Correlate to select the events of the manager, i.e. concurrently with
+	// the Processes, hence it must only depend on @e.
+	Correlate(e event.Event) string
+
+	// Start returns a new Process @id, which is started by @e (nil: @e does not start a Process).
+	Start(id string, e event.Event) (Process, error)
+}
+
+// Process is a single instance of a workflow. It keeps its own state, and is
+// only ever called by the bus from one goroutine at a time.
+type Process interface {
+	// Handle reacts to @e, which is either correlated with the Process, or the CommandDone of one
+	// of its successful steps. It returns the steps to take next, and whether the Process is done.
+	// Returning an error fails the Process.
+	Handle(e event.Event) (steps []Step, done bool, err error)
+}
+
+// Step is a command issued by a Process.
+type Step struct {
+	// Command to submit
+	Command *aggregate.Command
+
+	// Undoes the effect of Command after it has succeeded (nil: nothing to undo)
+	Compensation *aggregate.Command
+}
+
+// RegisterProcessManager runs the Processes of @pm on the local bus.
+func RegisterProcessManager(pm ProcessManager) (SubscriptionID, error) {
+	return localBus.RegisterProcessManager(pm)
+}
+
+// RegisterProcessManager runs the Processes of @pm on @m, until the returned subscription is removed (see Unsubscribe).
+//
+// A Process fails when one of its steps fails, or when its Handle function returns an error.
+// The bus then submits the compensations of its successful steps one at a time, in reverse order,
+// and ends the Process once all compensations and all of its steps under way have completed.
+func (m *MagicBus) RegisterProcessManager(pm ProcessManager) (SubscriptionID, error) {
+	var r = &processRunner{bus: m, manager: pm, processes: map[string]*process{}, steps: map[string]*process{}}
+
+	if pm == nil {
+		return SubscriptionID{}, fault.New(fault.Rejected, "attempt to register a nil process manager")
+	}
+	// Only the events the manager correlates count as consumed by it (see deadLetterEvent).
+	return m.Subscribe(r.handleEvent, Where(func(e event.Event) bool {
+		_, isCmdDone := e.(*event.CommandDone)
+		return isCmdDone || pm.Correlate(e) != ""
+	}))
+}
+
+// processRunner runs the Processes of a ProcessManager. It is called by a single subscription, i.e. one event at a time.
+type processRunner struct {
+	bus     *MagicBus
+	manager ProcessManager
+
+	// Active Processes (map { correlation ID -> process })
+	processes map[string]*process
+
+	// Processes waiting for the completion of a command (map { command ID -> process })
+	steps map[string]*process
+}
+
+// process tracks the progress of a Process.
+type process struct {
+	Process
+
+	// Correlation ID of the Process
+	id string
+
+	// Steps under way (map { command ID -> Step })
+	pending map[string]Step
+
+	// Compensations of the successful steps, in order of completion
+	compensations []*aggregate.Command
+
+	// Command ID of the compensation under way ("": none)
+	compensating string
+
+	// Reason why the Process failed (nil: not failed)
+	err error
+}
+
+// handleEvent passes @e on to the Process it belongs to.
+func (r *processRunner) handleEvent(e event.Event) {
+	if cd, ok := e.(*event.CommandDone); ok {
+		if p, ok := r.steps[cd.CmdID]; ok {
+			delete(r.steps, cd.CmdID)
+			r.commandDone(p, cd)
+			return
+		}
+	}
+
+	id := r.manager.Correlate(e)
+	if id == "" {
+		return
+	}
+
+	p, ok := r.processes[id]
+	if !ok {
+		proc, err := r.manager.Start(id, e)
+		if err != nil {
+			logger.Errorf("magicbus: process %s: failed to start on %s: %s", id, e, err)
+			return
+		} else if proc == nil {
+			return
+		}
+		p = &process{Process: proc, id: id, pending: map[string]Step{}}
+		r.processes[id] = p
+		logger.Debugf("magicbus: process %s: started by %s", id, e)
+	} else if p.err != nil { // compensating
+		return
+	}
+	r.handle(p, e)
+}
+
+// commandDone updates @p with the completion @cd of one of its commands.
+func (r *processRunner) commandDone(p *process, cd *event.CommandDone) {
+	if cd.CmdID == p.compensating {
+		if cd.Error != "" {
+			logger.Errorf("magicbus: process %s: compensation %s failed: %s", p.id, cd.Desc, cd.Error)
+		}
+		p.compensating = ""
+		r.compensate(p)
+		return
+	}
+
+	step := p.pending[cd.CmdID]
+	delete(p.pending, cd.CmdID)
+
+	if cd.Error != "" {
+		r.fail(p, fault.Wrapf(cd.Result().Err, fault.Internal, "step %s failed", cd.Desc))
+		return
+	} else if step.Compensation != nil {
+		p.compensations = append(p.compensations, step.Compensation)
+	}
+
+	// The step itself has taken effect, hence it is compensated along with the others if its follow-ups failed.
+	if err := cd.Result().BranchErr(); err != nil {
+		r.fail(p, fault.Wrapf(err, fault.Internal, "follow-up of step %s failed", cd.Desc))
+		return
+	}
+
+	if p.err != nil {
+		r.compensate(p)
+	} else {
+		r.handle(p, cd)
+	}
+}
+
+// handle passes @e to @p, and takes the resulting steps.
+func (r *processRunner) handle(p *process, e event.Event) {
+	steps, done, err := p.Handle(e)
+	if err != nil {
+		r.fail(p, err)
+		return
+	}
+
+	for _, step := range steps {
+		if step.Command == nil {
+			r.fail(p, fault.New(fault.Rejected, "nil step command"))
+			return
+		}
+		p.pending[step.Command.ID()] = step
+		r.steps[step.Command.ID()] = p
+
+		if err := r.bus.Submit(step.Command); err != nil {
+			delete(p.pending, step.Command.ID())
+			delete(r.steps, step.Command.ID())
+			r.fail(p, fault.Wrapf(err, fault.Unavailable, "failed to submit %s", step.Command))
+			return
+		}
+	}
+
+	if done {
+		r.end(p)
+	}
+}
+
+// fail starts compensating the successful steps of @p. Once @p has failed, further failures
+// only continue the compensation, e.g. to end @p after its last step under way has failed.
+func (r *processRunner) fail(p *process, err error) {
+	if p.err == nil {
+		logger.Errorf("magicbus: process %s: %s - compensating %d step(s)", p.id, err, len(p.compensations))
+		p.err = err
+	}
+	r.compensate(p)
+}
+
+// compensate submits the next compensation of @p, or ends @p once there is nothing left to wait for.
+func (r *processRunner) compensate(p *process) {
+	for p.compensating == "" && len(p.compensations) > 0 {
+		cmd := p.compensations[len(p.compensations)-1]
+		p.compensations = p.compensations[:len(p.compensations)-1]
+
+		if err := r.bus.Submit(cmd); err != nil {
+			logger.Errorf("magicbus: process %s: failed to submit compensation %s: %s", p.id, cmd, err)
+			continue
+		}
+		p.compensating = cmd.ID()
+		r.steps[cmd.ID()] = p
+	}
+
+	if p.compensating == "" && len(p.pending) == 0 {
+		r.end(p)
+	}
+}
+
+// end removes @p. The completion of any steps still under way is ignored.
+func (r *processRunner) end(p *process) {
+	for id := range p.pending {
+		delete(r.steps, id)
+	}
+	delete(r.processes, p.id)
+
+	if p.err != nil {
+		logger.Warningf("magicbus: process %s: ended after failure (%s)", p.id, p.err)
+	} else {
+		logger.Debugf("magicbus: process %s: done", p.id)
+	}
+}
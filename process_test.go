@@ -0,0 +1,353 @@
+package magicbus
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/pkg/errors"
+)
+
+func TestProcessManager(t *testing.T) {
+	var cpus = &pool{id: aggregate.NewID(aggregate.ResourceType_CPU, "pool"), capacity: 8}
+	var memory = &pool{id: aggregate.NewID(aggregate.ResourceType_MEMORY, "pool"), capacity: 1024}
+
+	m := NewMagicBus(context.Background())
+	defer m.Shutdown()
+
+	for _, p := range []*pool{cpus, memory} {
+		if err := m.Register(p, true); err != nil {
+			t.Fatalf("failed to register %s: %s", p.id, err)
+		}
+	}
+
+	var done = make(chan string, 2)
+	sid, err := m.RegisterProcessManager(&provisioning{cpus: cpus.id, memory: memory.id, done: done})
+	if err != nil {
+		t.Fatalf("failed to register process manager: %s", err)
+	}
+	defer m.Unsubscribe(sid)
+
+	// Both steps succeed.
+	if err = m.Publish(&provisionRequested{Name: "vm1", CPUs: 2, Bytes: 512}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	select {
+	case name := <-done:
+		if name != "vm1" {
+			t.Fatalf("unexpected completion of %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for provisioning of vm1")
+	}
+
+	// The memory step fails, which releases the CPUs reserved by the first step.
+	if err = m.Publish(&provisionRequested{Name: "vm2", CPUs: 4, Bytes: 1024}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+
+	var expected = []string{"reserve 2", "reserve 4", "release 4"}
+	for start := time.Now(); !reflect.DeepEqual(cpus.log(), expected); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("expected CPU operations %v, got %v", expected, cpus.log())
+		}
+	}
+	if ops := memory.log(); !reflect.DeepEqual(ops, []string{"reserve 512"}) {
+		t.Fatalf("unexpected memory operations %v", ops)
+	}
+
+	select {
+	case name := <-done:
+		t.Fatalf("unexpected completion of %s", name)
+	default:
+	}
+
+	// Events which no Process correlates are not consumed by the process manager.
+	var letters = make(chan DeadLetter, 1)
+	m.SubscribeDeadLetters(func(d DeadLetter) { letters <- d })
+
+	nowhere := aggregate.NewID(aggregate.ResourceType_MEMORY, "nowhere")
+	if err = m.Publish(mkTestEvent(nowhere, nowhere, "uncorrelated")); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	select {
+	case <-letters:
+	case <-time.After(time.Second):
+		t.Fatalf("uncorrelated event was not dead-lettered")
+	}
+}
+
+func TestProcessFailures(t *testing.T) {
+	var cpus = &pool{id: aggregate.NewID(aggregate.ResourceType_CPU, "failures"), capacity: 8}
+	var g = &gate{id: aggregate.NewID(aggregate.ResourceType_MEMORY, "gate"), open: make(chan struct{}), next: cpus.id}
+
+	m := NewMagicBus(context.Background())
+	defer m.Shutdown()
+
+	for _, a := range []aggregate.Aggregate{cpus, g} {
+		if err := m.Register(a, true); err != nil {
+			t.Fatalf("failed to register %s: %s", a.AggregateID(), err)
+		}
+	}
+
+	var started = make(chan string, 10)
+	sid, err := m.RegisterProcessManager(&parallel{cpus: cpus.id, gate: g.id, started: started})
+	if err != nil {
+		t.Fatalf("failed to register process manager: %s", err)
+	}
+	defer m.Unsubscribe(sid)
+
+	// awaitLog waits until the operations of @a are @expected.
+	awaitLog := func(a interface{ log() []string }, expected ...string) {
+		for start := time.Now(); !reflect.DeepEqual(a.log(), expected); time.Sleep(10 * time.Millisecond) {
+			if time.Since(start) > time.Second {
+				t.Fatalf("expected operations %v, got %v", expected, a.log())
+			}
+		}
+	}
+
+	// awaitStart waits until a Process @name has been started.
+	awaitStart := func(name string, timeout time.Duration) bool {
+		for {
+			select {
+			case id := <-started:
+				if id == name {
+					return true
+				}
+			case <-time.After(timeout):
+				return false
+			}
+		}
+	}
+
+	// awaitEnd waits until Process @name has ended, i.e. a new one is started for the same correlation ID.
+	awaitEnd := func(name string) {
+		for start := time.Now(); time.Since(start) < time.Second; {
+			if err := m.Publish(&parallelRequested{Name: name, Probe: true}); err != nil {
+				t.Fatalf("failed to publish: %s", err)
+			} else if awaitStart(name, 20*time.Millisecond) {
+				return
+			}
+		}
+		t.Fatalf("process %s did not end", name)
+	}
+
+	// 1. Two of three parallel steps fail, the second one after the compensation of the successful step.
+	if err = m.Publish(&parallelRequested{Name: "p1"}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if !awaitStart("p1", time.Second) {
+		t.Fatalf("process p1 was not started")
+	}
+	awaitLog(cpus, "reserve 2", "release 2")
+	g.open <- struct{}{}
+	awaitEnd("p1")
+
+	// 2. A step whose follow-up fails after the failure of another step is compensated, too.
+	if err = m.Publish(&parallelRequested{Name: "p2", FollowUp: true}); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if !awaitStart("p2", time.Second) {
+		t.Fatalf("process p2 was not started")
+	}
+	awaitLog(cpus, "reserve 2", "release 2", "reserve 2", "release 2")
+	g.open <- struct{}{}
+	awaitEnd("p2")
+	awaitLog(g, "pass", "undo")
+}
+
+// provisionRequested starts the provisioning of CPUs and memory.
+type provisionRequested struct {
+	Name  string
+	CPUs  uint64
+	Bytes uint64
+}
+
+func (p *provisionRequested) Source() aggregate.ID { return aggregate.ID{} }
+func (p *provisionRequested) Dest() aggregate.ID   { return aggregate.ID{} }
+
+// reserve and release are the commands handled by a pool.
+type reserve struct{ Amount uint64 }
+type release struct{ Amount uint64 }
+
+// pool is an aggregate which hands out a limited resource.
+type pool struct {
+	id       aggregate.ID
+	capacity uint64
+	reserved uint64
+
+	mu  sync.Mutex
+	ops []string
+}
+
+func (p *pool) AggregateID() aggregate.ID { return p.id }
+
+func (p *pool) HandleCommand(cmd *aggregate.Command) (*aggregate.Command, interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch c := cmd.Data().(type) {
+	case reserve:
+		if p.reserved+c.Amount > p.capacity {
+			return nil, nil, errors.Errorf("unable to reserve %d: exhausted", c.Amount)
+		}
+		p.reserved += c.Amount
+		p.ops = append(p.ops, fmt.Sprintf("reserve %d", c.Amount))
+	case release:
+		p.reserved -= c.Amount
+		p.ops = append(p.ops, fmt.Sprintf("release %d", c.Amount))
+	default:
+		return nil, nil, errors.Errorf("unsupported command %s", cmd)
+	}
+	return nil, p.reserved, nil
+}
+
+// log returns the successful operations of @p so far.
+func (p *pool) log() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.ops...)
+}
+
+// provisioning reserves CPUs, then memory, for each provisionRequested event.
+type provisioning struct {
+	cpus, memory aggregate.ID
+	done         chan<- string
+}
+
+func (p *provisioning) Correlate(e event.Event) string {
+	if req, ok := e.(*provisionRequested); ok {
+		return req.Name
+	}
+	return ""
+}
+
+func (p *provisioning) Start(id string, e event.Event) (Process, error) {
+	return &provisioningProcess{provisioning: p, name: id}, nil
+}
+
+// provisioningProcess is the state of a single provisioning workflow.
+type provisioningProcess struct {
+	*provisioning
+	name  string
+	bytes uint64
+}
+
+func (p *provisioningProcess) Handle(e event.Event) ([]Step, bool, error) {
+	switch e := e.(type) {
+	case *provisionRequested:
+		p.bytes = e.Bytes
+		return []Step{p.step(p.cpus, e.CPUs)}, false, nil
+	case *event.CommandDone:
+		if e.Source() == p.cpus {
+			return []Step{p.step(p.memory, p.bytes)}, false, nil
+		}
+		p.done <- p.name
+		return nil, true, nil
+	}
+	return nil, false, errors.Errorf("unexpected event %v", e)
+}
+
+// step returns the step which reserves @amount from @pool.
+func (p *provisioningProcess) step(pool aggregate.ID, amount uint64) Step {
+	cmd, _ := aggregate.NewLocalCommand(pool, reserve{Amount: amount})
+	undo, _ := aggregate.NewLocalCommand(pool, release{Amount: amount})
+	return Step{Command: cmd, Compensation: undo}
+}
+
+// parallelRequested starts a Process of parallel steps, or probes whether the Process @Name is still active.
+type parallelRequested struct {
+	Name     string
+	FollowUp bool
+	Probe    bool
+}
+
+func (p *parallelRequested) Source() aggregate.ID { return aggregate.ID{} }
+func (p *parallelRequested) Dest() aggregate.ID   { return aggregate.ID{} }
+
+// pass and undo are the commands handled by a gate.
+type pass struct{ FollowUp bool }
+type undo struct{}
+
+// gate is an aggregate which holds each pass command until the test opens it.
+// The command then fails, or succeeds with a follow-up which fails.
+type gate struct {
+	id   aggregate.ID
+	open chan struct{}
+	next aggregate.ID // target of the follow-up
+
+	mu  sync.Mutex
+	ops []string
+}
+
+func (g *gate) AggregateID() aggregate.ID { return g.id }
+
+func (g *gate) HandleCommand(cmd *aggregate.Command) (*aggregate.Command, interface{}, error) {
+	switch c := cmd.Data().(type) {
+	case pass:
+		<-g.open
+		if !c.FollowUp {
+			return nil, nil, errors.Errorf("gate closed")
+		}
+		g.record("pass")
+		next, _ := aggregate.NewLocalCommand(g.next, reserve{Amount: 100})
+		return next, nil, nil
+	case undo:
+		g.record("undo")
+		return nil, nil, nil
+	}
+	return nil, nil, errors.Errorf("unsupported command %s", cmd)
+}
+
+func (g *gate) record(op string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.ops = append(g.ops, op)
+}
+
+// log returns the successful operations of @g so far.
+func (g *gate) log() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.ops...)
+}
+
+// parallel takes three steps at once: a successful one, one which fails right away, and one held by a gate.
+type parallel struct {
+	cpus, gate aggregate.ID
+	started    chan<- string
+}
+
+func (p *parallel) Correlate(e event.Event) string {
+	if req, ok := e.(*parallelRequested); ok {
+		return req.Name
+	}
+	return ""
+}
+
+func (p *parallel) Start(id string, e event.Event) (Process, error) {
+	p.started <- id
+	if e.(*parallelRequested).Probe {
+		return nil, nil
+	}
+	return p, nil
+}
+
+func (p *parallel) Handle(e event.Event) ([]Step, bool, error) {
+	req, ok := e.(*parallelRequested)
+	if !ok {
+		return nil, false, nil
+	}
+	reserved, _ := aggregate.NewLocalCommand(p.cpus, reserve{Amount: 2})
+	released, _ := aggregate.NewLocalCommand(p.cpus, release{Amount: 2})
+	exhausted, _ := aggregate.NewLocalCommand(p.cpus, reserve{Amount: 100})
+	held, _ := aggregate.NewLocalCommand(p.gate, pass{FollowUp: req.FollowUp})
+	undone, _ := aggregate.NewLocalCommand(p.gate, undo{})
+
+	return []Step{{Command: reserved, Compensation: released}, {Command: exhausted}, {Command: held, Compensation: undone}}, false, nil
+}
@@ -0,0 +1,146 @@
+package magicbus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// Recorder keeps the causal history of the commands and events on a bus (see WithRecorder),
+// grouped by correlation ID, so that the chain of a multi-step workflow can be retraced.
+// Events are only recorded if they carry a correlation ID (see event.MetadataCarrier).
+type Recorder struct {
+	sync.Mutex
+
+	// Maximum number of retained chains, oldest chains are discarded first (<= 0: unlimited)
+	capacity int
+
+	// Recorded chains (map { correlation ID -> chain }), and their correlation IDs in order of arrival
+	chains map[string]*chain
+	order  []string
+}
+
+// chain is the causal history of a single correlation ID.
+type chain struct {
+	// Recorded commands, in order of arrival (map { command ID -> node })
+	commands map[string]*CausalNode
+	order    []*CausalNode
+
+	// Events, in order of arrival
+	events []event.Event
+}
+
+// CausalNode is a command in a causal tree, with the events and commands it caused.
+type CausalNode struct {
+	Command  *aggregate.Command // Command represented by this node (nil if not recorded)
+	Time     time.Time          // When the command was first seen by the bus
+	Events   []event.Event      // Events caused by Command, in order of arrival
+	Children []*CausalNode      // Commands caused by Command, in order of arrival
+}
+
+// NewRecorder returns a Recorder which retains the history of the @capacity most recent chains (<= 0: unlimited).
+func NewRecorder(capacity int) *Recorder {
+	return &Recorder{capacity: capacity, chains: map[string]*chain{}}
+}
+
+// Tree returns the causal tree of correlation ID @id, rooted in the command which started the chain.
+// Commands and events whose cause was not recorded (e.g. handled on a remote bus) are attached to the root.
+func (r *Recorder) Tree(id string) (*CausalNode, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	c, ok := r.chains[id]
+	if !ok {
+		return nil, fault.New(fault.NotFound, "no causal history of %s", id)
+	}
+
+	// Build a copy, so that the result is not affected by further recording.
+	var root = &CausalNode{}
+	var nodes = map[string]*CausalNode{}
+
+	for _, n := range c.order {
+		nodes[n.Command.ID()] = &CausalNode{Command: n.Command, Time: n.Time}
+	}
+	if n, ok := nodes[id]; ok {
+		root = n
+	}
+
+	var parent = func(causationID string) *CausalNode {
+		if p, ok := nodes[causationID]; ok {
+			return p
+		}
+		return root
+	}
+	for _, n := range c.order {
+		if n.Command.ID() != id {
+			p := parent(n.Command.CausationID())
+			p.Children = append(p.Children, nodes[n.Command.ID()])
+		}
+	}
+	for _, e := range c.events {
+		p := parent(event.MetadataOf(e).CausationID())
+		p.Events = append(p.Events, e)
+	}
+	return root, nil
+}
+
+// recordCommand adds @cmd to the history, unless already recorded (e.g. on retry).
+func (r *Recorder) recordCommand(cmd *aggregate.Command) {
+	r.Lock()
+	defer r.Unlock()
+
+	c := r.chain(cmd.CorrelationID())
+	if _, ok := c.commands[cmd.ID()]; !ok {
+		n := &CausalNode{Command: cmd, Time: time.Now()}
+		c.commands[cmd.ID()] = n
+		c.order = append(c.order, n)
+	}
+}
+
+// recordEvent adds @e to the history, if it is part of a chain.
+func (r *Recorder) recordEvent(e event.Event) {
+	var id = event.MetadataOf(e).CorrelationID()
+
+	if id == "" {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	c := r.chain(id)
+	c.events = append(c.events, e)
+}
+
+// chain returns the chain of correlation ID @id, creating it if necessary. Must be called with @r locked.
+func (r *Recorder) chain(id string) *chain {
+	if c, ok := r.chains[id]; ok {
+		return c
+	}
+
+	if r.capacity > 0 && len(r.order) >= r.capacity {
+		delete(r.chains, r.order[0])
+		r.order = r.order[1:]
+	}
+	c := &chain{commands: map[string]*CausalNode{}}
+	r.chains[id] = c
+	r.order = append(r.order, id)
+	return c
+}
+
+// recordCommand adds @cmd to the causal history of @m, if enabled.
+func (m *MagicBus) recordCommand(cmd *aggregate.Command) {
+	if m.recorder != nil {
+		m.recorder.recordCommand(cmd)
+	}
+}
+
+// recordEvent adds @e to the causal history of @m, if enabled.
+func (m *MagicBus) recordEvent(e event.Event) {
+	if m.recorder != nil {
+		m.recorder.recordEvent(e)
+	}
+}
@@ -0,0 +1,106 @@
+package magicbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+func TestRecorder(t *testing.T) {
+	var cpus = &pool{id: aggregate.NewID(aggregate.ResourceType_CPU, "recorded"), capacity: 8}
+	var memory = &pool{id: aggregate.NewID(aggregate.ResourceType_MEMORY, "recorded"), capacity: 1024}
+	var planner = &plannerAggregate{id: aggregate.NewID(aggregate.ResourceType_CPU, "recplanner"), cpus: cpus.id, memory: memory.id}
+	var r = NewRecorder(10)
+
+	m := NewMagicBus(context.Background(), WithRecorder(r))
+	defer m.Shutdown()
+
+	for _, a := range []aggregate.Aggregate{cpus, memory, planner} {
+		if err := m.Register(a, true); err != nil {
+			t.Fatalf("failed to register %s: %s", a.AggregateID(), err)
+		}
+	}
+
+	cmd, _ := aggregate.NewLocalCommand(planner.id, provision{CPUs: 1, Bytes: 1})
+	if res := m.LaunchWait(cmd, time.Second); res.Err != nil {
+		t.Fatalf("%s failed: %s", cmd, res.Err)
+	}
+
+	// The fan-out branches and the join are caused by the command, as are all CommandDone events.
+	tree, err := r.Tree(cmd.ID())
+	if err != nil {
+		t.Fatalf("no causal tree of %s: %s", cmd, err)
+	} else if tree.Command != cmd {
+		t.Fatalf("expected %s as root, got %v", cmd, tree.Command)
+	} else if len(tree.Children) != 3 {
+		t.Fatalf("expected 3 follow-up commands, got %d", len(tree.Children))
+	}
+
+	for _, n := range append([]*CausalNode{tree}, tree.Children...) {
+		if n.Command.CorrelationID() != cmd.ID() {
+			t.Fatalf("%s: unexpected correlation ID %s", n.Command, n.Command.CorrelationID())
+		} else if len(n.Events) != 1 {
+			t.Fatalf("%s: expected the CommandDone event only, got %v", n.Command, n.Events)
+		} else if cd, ok := n.Events[0].(*event.CommandDone); !ok || cd.CmdID != n.Command.ID() {
+			t.Fatalf("%s: unexpected event %v", n.Command, n.Events[0])
+		} else if n != tree && (n.Command.CausationID() != cmd.ID() || len(n.Children) != 0) {
+			t.Fatalf("%s: unexpected cause %s", n.Command, n.Command.CausationID())
+		}
+	}
+
+	if _, err := r.Tree("unknown"); !errors.Is(err, fault.ErrNotFound) {
+		t.Fatalf("expected unknown correlation ID not to be found, got %v", err)
+	}
+
+	// Of the events published while handling a command, only those which carry metadata are part of its
+	// chain. Others are delivered as published, without correlation and causation IDs.
+	var announcer = &announcerAggregate{id: aggregate.NewID(aggregate.ResourceType_MEMORY, "announcer"), bus: m}
+	if err := m.Register(announcer, true); err != nil {
+		t.Fatalf("failed to register %s: %s", announcer.id, err)
+	}
+	var plain = make(chan event.Event, 1)
+	if _, err := m.Subscribe(func(e event.Event) { plain <- e }, ByEventType(&testEvent{})); err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+
+	cmd, _ = aggregate.NewLocalCommand(announcer.id, "announce")
+	if res := m.LaunchWait(cmd, time.Second); res.Err != nil {
+		t.Fatalf("%s failed: %s", cmd, res.Err)
+	}
+	select {
+	case e := <-plain:
+		if md := event.MetadataOf(e); md != nil {
+			t.Fatalf("unexpected metadata %v of %v", md, e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("event without metadata was not delivered")
+	}
+
+	if tree, err = r.Tree(cmd.ID()); err != nil {
+		t.Fatalf("no causal tree of %s: %s", cmd, err)
+	} else if len(tree.Events) != 2 {
+		t.Fatalf("expected 2 events caused by %s, got %v", cmd, tree.Events)
+	} else if _, ok := tree.Events[0].(*allocated); !ok {
+		t.Fatalf("unexpected event %v", tree.Events[0])
+	}
+}
+
+// announcerAggregate publishes an event which carries metadata, and one which does not, for each command it handles.
+type announcerAggregate struct {
+	id  aggregate.ID
+	bus *MagicBus
+}
+
+func (a *announcerAggregate) AggregateID() aggregate.ID { return a.id }
+
+func (a *announcerAggregate) HandleCommand(cmd *aggregate.Command) (*aggregate.Command, interface{}, error) {
+	if err := a.bus.Publish(&allocated{Src: a.id, Bytes: 1}); err != nil {
+		return nil, nil, err
+	}
+	return nil, nil, a.bus.Publish(mkTestEvent(a.id, a.id, "announced"))
+}
@@ -5,18 +5,49 @@ import (
 
 	"github.com/grrtrr/magicbus/aggregate"
 	"github.com/grrtrr/magicbus/event"
-	"github.com/pkg/errors"
+	"github.com/grrtrr/magicbus/fault"
+	"github.com/grrtrr/magicbus/transport"
 )
 
 /*
  * Dealing with remote commands and events.
  */
-// remoteSubmit sends @cmd to the remote bus specified by cmd.AggregateID()
-func remoteSubmit(ctx context.Context, cmd *aggregate.Command) error {
-	return errors.Errorf("remoteSubmit NOT IMPLEMENTED YET: integrete your remote method call here")
+// remoteSubmit sends @cmd to the remote bus specified by cmd.Dest()
+func (m *MagicBus) remoteSubmit(ctx context.Context, cmd *aggregate.Command) error {
+	if m.transport == nil {
+		return fault.New(fault.Unavailable, "unable to submit %s to %s: no remote transport configured", cmd, cmd.Dest())
+	}
+	m.recordCommand(cmd)
+	return m.transport.SendCommand(ctx, cmd)
 }
 
-// remotePublish forwards @evt to the remote event bus specified by @evt.To
-func remotePublish(ctx context.Context, evt event.Event) error {
-	return errors.Errorf("remotePublish NOT IMPLEMENTED YET: integrete your remote method call here")
+// remotePublish forwards @evt to the remote event bus specified by evt.Dest()
+func (m *MagicBus) remotePublish(ctx context.Context, evt event.Event) error {
+	if m.transport == nil {
+		return fault.New(fault.Unavailable, "unable to publish %v to %s: no remote transport configured", evt, evt.Dest())
+	}
+	m.recordEvent(evt)
+	return m.transport.SendEvent(ctx, evt)
+}
+
+// receive injects the commands and events received from remote buses into @m.
+func (m *MagicBus) receive(inbox <-chan transport.Message) {
+	for msg := range inbox {
+		var err error
+
+		if msg.Command != nil {
+			if !msg.Command.Dest().IsLocal() { // do not bounce commands between nodes
+				m.deadLetterCommand(msg.Command, fault.New(fault.NotFound, "destination %s is not on this node (%s)",
+					msg.Command.Dest(), aggregate.NodeID()))
+				continue
+			}
+			err = m.Submit(msg.Command)
+		} else if msg.Event != nil {
+			err = m.Publish(msg.Event)
+		}
+
+		if err != nil {
+			logger.Errorf("magicbus: failed to inject remote %s: %s", msg, err)
+		}
+	}
 }
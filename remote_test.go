@@ -0,0 +1,102 @@
+package magicbus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/codec"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/transport"
+)
+
+// remoteCommand and remoteEvent are exchanged with peers, hence registered with the codec.
+type remoteCommand struct {
+	Text string
+}
+
+type remoteEvent struct {
+	Src, Dst aggregate.ID
+	Text     string
+}
+
+func (r *remoteEvent) Source() aggregate.ID { return r.Src }
+func (r *remoteEvent) Dest() aggregate.ID   { return r.Dst }
+
+func init() {
+	if err := codec.RegisterCommand(remoteCommand{}); err != nil {
+		panic(err)
+	} else if err = codec.RegisterEvent(&remoteEvent{}); err != nil {
+		panic(err)
+	}
+}
+
+func TestLoopbackTransport(t *testing.T) {
+	var network = transport.NewLoopback()
+
+	local, err := network.Connect(aggregate.NodeID())
+	if err != nil {
+		t.Fatalf("failed to connect local node: %s", err)
+	}
+	peer, err := network.Connect("peerNode")
+	if err != nil {
+		t.Fatalf("failed to connect peer node: %s", err)
+	}
+	defer peer.Close()
+
+	m := NewMagicBus(context.Background(), WithTransport(local))
+	defer m.Shutdown()
+	defer local.Close()
+
+	src := aggregate.NewID(aggregate.ResourceType_CPU, "")
+	dst := aggregate.ID{Node: "peerNode", Type: aggregate.ResourceType_MEMORY}
+
+	// 1. Outgoing command
+	cmd, err := aggregate.NewCommand(src, dst, remoteCommand{"command one"})
+	if err != nil {
+		t.Fatalf("failed to set up command: %s", err)
+	} else if err = m.remoteSubmit(context.Background(), cmd); err != nil {
+		t.Fatalf("failed to submit %s: %s", cmd, err)
+	}
+
+	select {
+	case msg := <-peer.Receive():
+		if msg.Command == nil || msg.Command.ID() != cmd.ID() || msg.Command.Data() != cmd.Data() {
+			t.Fatalf("peer received unexpected message %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %s to reach peer", cmd)
+	}
+
+	// 2. Incoming event is injected into the bus
+	var received = make(chan event.Event, 1)
+
+	id, err := m.Observer(func(e event.Event) { received <- e })
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+	defer m.Unsubscribe(id)
+
+	te := &remoteEvent{Src: dst, Dst: src, Text: "reply from peer"}
+	if err := peer.SendEvent(context.Background(), te); err != nil {
+		t.Fatalf("peer failed to send %v: %s", te, err)
+	}
+
+	select {
+	case e := <-received:
+		if re, ok := e.(*remoteEvent); !ok || *re != *te {
+			t.Fatalf("observer received unexpected event %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %v to arrive from peer", te)
+	}
+
+	// 3. Without a transport, remote commands are refused
+	unconnected := NewMagicBus(context.Background())
+	defer unconnected.Shutdown()
+
+	if err := unconnected.remoteSubmit(context.Background(), cmd); err == nil {
+		t.Fatalf("expected error submitting remote command without a transport, but got nil")
+	}
+}
@@ -6,8 +6,8 @@ import (
 	"github.com/grrtrr/magicbus"
 	"github.com/grrtrr/magicbus/aggregate"
 	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
 	"github.com/grrtrr/magicbus/query"
-	"github.com/pkg/errors"
 )
 
 // Global Variables
@@ -35,16 +35,16 @@ func HandleQuery(q query.Argument) (results interface{}, err error) {
 	if repo, ok := registeredAggregates[q.AggregateID().Type]; ok {
 		return repo.Query(q)
 	}
-	return nil, errors.Errorf("no handler registered for %s query", q.AggregateID())
+	return nil, fault.New(fault.NotFound, "no handler registered for %s query", q.AggregateID())
 }
 
 // RegisterQueryHandler registers @h as handling queries pertaining to @subsystem (at package initialization time)
 func RegisterQueryHandler(r Repository) {
-	if _, err := magicbus.Observer(func(e event.Event) {
-		if e.Source().Node == aggregate.NodeID() && e.Source().Type == r.AggregateType() {
-			r.Update(e)
-		}
-	}); err != nil {
+	if _, err := magicbus.Subscribe(r.Update,
+		magicbus.ByResourceType(r.AggregateType()),
+		// The node ID may be set after package initialization, hence is evaluated per event.
+		magicbus.Where(func(e event.Event) bool { return e.Source().Node == aggregate.NodeID() }),
+	); err != nil {
 		logger.Fatalf("could not subscribe repository for %s domain-specific events: %s", r.AggregateType(), err)
 	}
 	registeredAggregates[r.AggregateType()] = r
@@ -0,0 +1,103 @@
+package magicbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+func TestTypedResults(t *testing.T) {
+	var id = aggregate.NewID(aggregate.ResourceType_MEMORY, "usage")
+
+	RegisterAggregate(&usageAggregate{id: id}, true)
+	defer UnregisterAggregate(id)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// The result keeps its type.
+	cmd, _ := aggregate.NewLocalCommand(id, "usage")
+	if u, err := LaunchTyped[*memoryUsage](ctx, cmd); err != nil {
+		t.Fatalf("%s failed: %s", cmd, err)
+	} else if u.Used != 64 || u.Free != 192 {
+		t.Fatalf("unexpected result %+v", u)
+	}
+
+	// A result of another type is an error.
+	cmd, _ = aggregate.NewLocalCommand(id, "usage")
+	if _, err := LaunchTyped[int](ctx, cmd); err == nil {
+		t.Fatalf("expected result type mismatch")
+	}
+
+	// The error keeps its code and details.
+	cmd, _ = aggregate.NewLocalCommand(id, "lookup")
+	_, err := LaunchTyped[*memoryUsage](ctx, cmd)
+	if !errors.Is(err, fault.ErrNotFound) {
+		t.Fatalf("expected not-found error, got %v", err)
+	}
+	var f *fault.Error
+	if !errors.As(err, &f) || f.Details["bank"] != "bank7" {
+		t.Fatalf("unexpected error %#v", err)
+	}
+}
+
+// memoryUsage is the result of the "usage" command.
+type memoryUsage struct {
+	Used, Free uint64
+}
+
+// usageAggregate reports its memory usage.
+type usageAggregate struct {
+	id aggregate.ID
+}
+
+func (u *usageAggregate) AggregateID() aggregate.ID { return u.id }
+
+func (u *usageAggregate) HandleCommand(cmd *aggregate.Command) (*aggregate.Command, interface{}, error) {
+	if cmd.Type() == "usage" {
+		return nil, &memoryUsage{Used: 64, Free: 192}, nil
+	}
+	return nil, nil, fault.New(fault.NotFound, "no such bank").WithDetail("bank", "bank7")
+}
+
+func TestErrorCodes(t *testing.T) {
+	var id = aggregate.NewID(aggregate.ResourceType_CPU, "codes")
+
+	m := NewMagicBus(context.Background())
+	defer m.Shutdown()
+
+	if err := m.Register(&panickyAggregate{id: id}, true); err != nil {
+		t.Fatalf("failed to register %s: %s", id, err)
+	} else if err = m.Register(nil, true); !errors.Is(err, fault.ErrRejected) {
+		t.Fatalf("expected nil aggregate to be rejected, got %v", err)
+	} else if _, err = m.SubscriptionStats(NewSubscriptionID()); !errors.Is(err, fault.ErrNotFound) {
+		t.Fatalf("expected unknown subscription not to be found, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		dst     aggregate.ID
+		data    string
+		maxWait time.Duration
+		code    *fault.Error
+	}{
+		{aggregate.NewID(aggregate.ResourceType_MEMORY, "unknown"), "instance", time.Second, fault.ErrNotFound},
+		{id, "panic", time.Second, fault.ErrInternal},
+		{id, "instance", 0, fault.ErrTimeout},
+	} {
+		cmd, _ := aggregate.NewLocalCommand(tc.dst, tc.data)
+		if res := m.LaunchWait(cmd, tc.maxWait); !errors.Is(res.Err, tc.code) {
+			t.Fatalf("%s => %s: expected %s error, got %v", cmd, tc.dst, tc.code.Code, res.Err)
+		}
+	}
+
+	// Launching on a shut down bus
+	m.Shutdown()
+	cmd, _ := aggregate.NewLocalCommand(id, "instance")
+	if res := m.LaunchWait(cmd, time.Second); !errors.Is(res.Err, fault.ErrUnavailable) {
+		t.Fatalf("expected bus to be unavailable, got %v", res.Err)
+	}
+}
@@ -0,0 +1,141 @@
+package magicbus
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// RetryPolicy determines whether and when a failed command is re-submitted.
+type RetryPolicy struct {
+	// Total number of attempts, including the first one (<= 1: no retries)
+	MaxAttempts int
+
+	// Delay before the first retry, doubled for each further retry
+	Backoff time.Duration
+
+	// Upper bound of the delay between attempts (0: unbounded)
+	MaxBackoff time.Duration
+
+	// Random variation of each delay, as fraction of the delay (0 .. 1)
+	Jitter float64
+
+	// Classifies errors as retryable (nil: all errors except fault.Permanent ones)
+	Retryable func(error) bool
+}
+
+// Upper bound of retry delays, leaving room for doubling and jitter without overflowing
+const maxRetryDelay = time.Duration(math.MaxInt64 / 4)
+
+// delay returns the time to wait before retrying after @attempt failed attempts.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	var d = p.Backoff
+
+	for i := 1; i < attempt && d < maxRetryDelay && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	} else if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	if p.Jitter > 0 {
+		d += time.Duration((2*rand.Float64() - 1) * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// retryable returns true if @err may go away on retrying.
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable == nil {
+		return !fault.Permanent(err)
+	}
+	return p.Retryable(err)
+}
+
+// retryKey is the context key of a per-command RetryPolicy.
+type retryKey struct{}
+
+// ContextWithRetry returns a copy of @ctx which makes the bus retry commands according to @p.
+// For a single command, it takes precedence over the policy of its type (see WithRetryPolicy):
+//
+//	cmd.WithContext(magicbus.ContextWithRetry(ctx, policy))
+//
+// NB: the policy is not transferred to remote buses.
+func ContextWithRetry(ctx context.Context, p RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryKey{}, p)
+}
+
+// retries keeps track of the attempts of commands with a RetryPolicy.
+type retries struct {
+	sync.Mutex
+
+	// Policies by command type, set at construction time
+	policies map[string]RetryPolicy
+
+	// Number of failed attempts so far, per submitted command (map { Command -> attempts }).
+	// Keyed by instance rather than CommandID, so that a command re-issued under the same ID starts afresh.
+	attempts map[*aggregate.Command]int
+}
+
+func newRetries() *retries {
+	return &retries{policies: map[string]RetryPolicy{}, attempts: map[*aggregate.Command]int{}}
+}
+
+// policy returns the RetryPolicy applying to @cmd, if any.
+func (r *retries) policy(cmd *aggregate.Command) (RetryPolicy, bool) {
+	if p, ok := cmd.Context().Value(retryKey{}).(RetryPolicy); ok {
+		return p, true
+	}
+	p, ok := r.policies[cmd.Type()]
+	return p, ok
+}
+
+// retry schedules @cmd for another attempt after it failed with @err, if its RetryPolicy permits.
+// Returns the number of attempts so far, and whether @cmd has been scheduled for retry.
+// Called from within the actor loop of the Aggregate that handled @cmd.
+func (m *MagicBus) retry(cmd *aggregate.Command, err error) (attempts int, scheduled bool) {
+	m.retries.Lock()
+	defer m.retries.Unlock()
+
+	attempts = m.retries.attempts[cmd] + 1
+
+	p, ok := m.retries.policy(cmd)
+	if err == nil || !ok || attempts >= p.MaxAttempts || !p.retryable(err) || cmd.Context().Err() != nil {
+		delete(m.retries.attempts, cmd)
+		return attempts, false
+	}
+	m.retries.attempts[cmd] = attempts
+
+	delay := p.delay(attempts)
+	logger.Infof("magicbus: %s failed (attempt %d/%d), retrying in %s: %s", cmd, attempts, p.MaxAttempts, delay, err)
+
+	// Re-submit via the bus, so that the Aggregate is free to process other commands in the meantime.
+	// If that fails, the issuer is notified like for any other undeliverable command.
+	time.AfterFunc(delay, func() {
+		if err := m.Actor.Submit(cmd); err == nil {
+			return
+		} else if m.IsActive() {
+			logger.Errorf("magicbus: failed to retry %s: %s", cmd, err)
+			m.deadLetterCommand(cmd, fault.Wrapf(err, fault.Unavailable, "failed to retry %s", cmd))
+		} else {
+			m.forgetAttempts(cmd)
+		}
+	})
+	return attempts, true
+}
+
+// forgetAttempts discards the retry state of @cmd, returning the number of failed attempts so far.
+func (m *MagicBus) forgetAttempts(cmd *aggregate.Command) (attempts int) {
+	m.retries.Lock()
+	defer m.retries.Unlock()
+
+	attempts = m.retries.attempts[cmd]
+	delete(m.retries.attempts, cmd)
+	return attempts
+}
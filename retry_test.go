@@ -0,0 +1,147 @@
+package magicbus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/pkg/errors"
+)
+
+func TestRetry(t *testing.T) {
+	var id = aggregate.NewID(aggregate.ResourceType_CPU, "flaky")
+	var errPermanent = errors.New("permanent failure")
+
+	m := NewMagicBus(context.Background(), WithRetryPolicy("flaky", RetryPolicy{
+		MaxAttempts: 5,
+		Backoff:     time.Millisecond,
+		Jitter:      0.5,
+	}))
+	defer m.Shutdown()
+
+	a := &flakyAggregate{id: id, failures: map[string]int{}}
+	if err := m.Register(a, true); err != nil {
+		t.Fatalf("failed to register %s: %s", id, err)
+	}
+
+	var done = make(chan *event.CommandDone, 10)
+	if _, err := m.Subscribe(func(e event.Event) { done <- e.(*event.CommandDone) }, ByEventType(&event.CommandDone{})); err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		data     string       // command data
+		fail     int          // number of times the command fails
+		policy   *RetryPolicy // per-command policy (nil: per-type policy)
+		attempts int          // expected number of attempts
+		failed   bool         // whether the command is expected to fail in the end
+	}{
+		{"policy by type", "flaky", 2, nil, 3, false},
+		{"no policy", "unreliable", 2, nil, 1, true},
+		{"exhausted", "unreliable", 5, &RetryPolicy{MaxAttempts: 2}, 2, true},
+		{"not retryable", "flaky", 5, &RetryPolicy{MaxAttempts: 5, Retryable: func(err error) bool {
+			return err != errPermanent
+		}}, 1, true},
+	} {
+		a.reset(tc.data, tc.fail, errPermanent)
+
+		cmd, _ := aggregate.NewLocalCommand(id, tc.data)
+		if tc.policy != nil {
+			cmd.WithContext(ContextWithRetry(context.Background(), *tc.policy))
+		}
+
+		res := m.LaunchWait(cmd, time.Second)
+		if failed := res.Err != nil; failed != tc.failed {
+			t.Fatalf("%s: unexpected result %s", tc.name, res)
+		}
+
+		select {
+		case cd := <-done:
+			if cd.Attempts != tc.attempts {
+				t.Fatalf("%s: expected %d attempts, got %d", tc.name, tc.attempts, cd.Attempts)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: timed out waiting for CommandDone", tc.name)
+		}
+	}
+}
+
+func TestRetryUndeliverable(t *testing.T) {
+	var id = aggregate.NewID(aggregate.ResourceType_CPU, "vanishing")
+
+	m := NewMagicBus(context.Background(), WithRetryPolicy("flaky", RetryPolicy{MaxAttempts: 3, Backoff: 100 * time.Millisecond}))
+	defer m.Shutdown()
+
+	a := &flakyAggregate{id: id, failures: map[string]int{"flaky": 1}, err: errors.New("transient failure")}
+	if err := m.Register(a, true); err != nil {
+		t.Fatalf("failed to register %s: %s", id, err)
+	}
+
+	var done = make(chan *event.CommandDone, 1)
+	if _, err := m.Subscribe(func(e event.Event) { done <- e.(*event.CommandDone) }, ByEventType(&event.CommandDone{})); err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+
+	// The aggregate goes away while the command waits for its retry.
+	cmd, _ := aggregate.NewLocalCommand(id, "flaky")
+	if err := m.Submit(cmd); err != nil {
+		t.Fatalf("failed to submit %s: %s", cmd, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := m.Unregister(id); err != nil {
+		t.Fatalf("failed to unregister %s: %s", id, err)
+	}
+
+	select {
+	case cd := <-done:
+		if cd.Error == "" || cd.Attempts != 1 {
+			t.Fatalf("expected failed CommandDone after 1 attempt, got %s (%d attempts)", cd, cd.Attempts)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for CommandDone")
+	}
+
+	m.retries.Lock()
+	defer m.retries.Unlock()
+	if n := len(m.retries.attempts); n != 0 {
+		t.Fatalf("expected no retry state after dead-lettering, found %d entries", n)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	var p = RetryPolicy{Backoff: time.Second, Jitter: 1}
+
+	// Unbounded delays do not overflow.
+	for _, attempt := range []int{1, 10, 62, 63, 64, 1000} {
+		if d := p.delay(attempt); d < 0 {
+			t.Fatalf("negative delay %s after %d attempts", d, attempt)
+		}
+	}
+}
+
+// flakyAggregate fails each command a configurable number of times before succeeding.
+type flakyAggregate struct {
+	id       aggregate.ID
+	failures map[string]int
+	err      error
+}
+
+// reset makes @f fail @n times on commands @data with @err. Called before submitting the command.
+func (f *flakyAggregate) reset(data string, n int, err error) {
+	f.failures[data], f.err = n, err
+}
+
+func (f *flakyAggregate) AggregateID() aggregate.ID {
+	return f.id
+}
+
+func (f *flakyAggregate) HandleCommand(cmd *aggregate.Command) (*aggregate.Command, interface{}, error) {
+	if f.failures[cmd.Type()] > 0 {
+		f.failures[cmd.Type()]--
+		return nil, nil, f.err
+	}
+	return nil, "ok", nil
+}
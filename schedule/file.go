@@ -0,0 +1,171 @@
+package schedule
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/grrtrr/magicbus/codec"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// GLOBAL VARIABLES
+var logger = logrus.WithField("module", "schedule")
+
+// FileStore is a Store which keeps all entries in a single JSON file.
+//
+// Since schedules are few and change rarely, the file is rewritten on each change:
+// the new content is written to a temporary file, synced, and renamed over the old one,
+// so that the file always contains either the old or the new set of entries.
+// Commands are stored as codec.Envelope, hence their types have to be registered
+// with the codec package in order to be read back. List skips entries which can not
+// be decoded, but retains them in the file.
+type FileStore struct {
+	sync.Mutex
+
+	// Path of the file
+	path string
+
+	// Current entries (map { ID -> entry })
+	entries map[string]fileEntry
+}
+
+// fileEntry is the on-disk representation of an Entry.
+type fileEntry struct {
+	ID       string          `json:"id"`
+	Command  *codec.Envelope `json:"command"`
+	Next     time.Time       `json:"next"`
+	Interval time.Duration   `json:"interval,omitempty"`
+}
+
+// OpenFile opens the FileStore at @path, which is created on the first change if it does not exist.
+func OpenFile(path string) (*FileStore, error) {
+	var s = &FileStore{path: path, entries: map[string]fileEntry{}}
+	var entries []fileEntry
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	} else if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, fault.Wrapf(err, fault.Internal, "%s: invalid schedule file", path)
+	}
+
+	for _, e := range entries {
+		s.entries[e.ID] = e
+	}
+	return s, nil
+}
+
+// Put implements Store.
+func (s *FileStore) Put(e Entry) error {
+	env, err := codec.EncodeCommand(e.Command)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	old, existed := s.entries[e.ID]
+	s.entries[e.ID] = fileEntry{ID: e.ID, Command: env, Next: e.Next, Interval: e.Interval}
+
+	if err = s.save(); err != nil { // keep memory and file in sync
+		if existed {
+			s.entries[e.ID] = old
+		} else {
+			delete(s.entries, e.ID)
+		}
+	}
+	return err
+}
+
+// Delete implements Store.
+func (s *FileStore) Delete(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	old, existed := s.entries[id]
+	if !existed {
+		return nil
+	}
+	delete(s.entries, id)
+
+	err := s.save()
+	if err != nil {
+		s.entries[id] = old
+	}
+	return err
+}
+
+// List implements Store.
+func (s *FileStore) List() ([]Entry, error) {
+	var entries []Entry
+
+	s.Lock()
+	defer s.Unlock()
+
+	for _, e := range s.entries {
+		cmd, err := codec.DecodeCommand(e.Command)
+		if err != nil {
+			logger.Warningf("%s: skipping schedule %s: %s", s.path, e.ID, err)
+			continue
+		}
+		entries = append(entries, Entry{ID: e.ID, Command: cmd, Next: e.Next, Interval: e.Interval})
+	}
+	return entries, nil
+}
+
+// Close implements Store.
+func (s *FileStore) Close() error {
+	return nil
+}
+
+// save atomically replaces the file by the current entries, and syncs the directory to persist the rename.
+func (s *FileStore) save() error {
+	var entries = make([]fileEntry, 0, len(s.entries))
+
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	} else if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(s.path))
+}
+
+// syncDir flushes the entries of directory @dir to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package schedule
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/codec"
+)
+
+func TestFileStore(t *testing.T) {
+	var dst = aggregate.NewID(aggregate.ResourceType_CPU, "scheduled")
+
+	dir, err := os.MkdirTemp("", "schedule")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "schedules")
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", path, err)
+	}
+
+	next := time.Now().Add(time.Minute).Round(0)
+	for _, id := range []string{"once", "recurring", "deleted"} {
+		cmd, _ := aggregate.NewLocalCommand(dst, "reconcile")
+		e := Entry{ID: id, Command: cmd, Next: next}
+		if id == "recurring" {
+			e.Interval = 5 * time.Minute
+		}
+		if err := s.Put(e); err != nil {
+			t.Fatalf("failed to store %s: %s", e, err)
+		}
+	}
+	if err := s.Delete("deleted"); err != nil {
+		t.Fatalf("failed to delete entry: %s", err)
+	}
+
+	// An entry which can not be decoded does not spoil the others.
+	s.entries["undecodable"] = fileEntry{ID: "undecodable", Next: next, Command: &codec.Envelope{
+		Kind: codec.KindCommand, Type: "unregistered", Src: dst, Dst: dst, Payload: json.RawMessage(`{"N":1}`),
+	}}
+	if err := s.save(); err != nil {
+		t.Fatalf("failed to save entries: %s", err)
+	}
+	s.Close()
+
+	// The entries survive re-opening the store.
+	if s, err = OpenFile(path); err != nil {
+		t.Fatalf("failed to re-open %s: %s", path, err)
+	}
+	defer s.Close()
+
+	entries, err := s.List()
+	if err != nil {
+		t.Fatalf("failed to list entries: %s", err)
+	} else if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", entries)
+	} else if _, retained := s.entries["undecodable"]; !retained {
+		t.Fatalf("undecodable entry was not retained")
+	}
+
+	for _, e := range entries {
+		if e.ID != "once" && e.ID != "recurring" {
+			t.Fatalf("unexpected entry %s", e)
+		} else if e.Command.Dest() != dst || e.Command.Type() != "reconcile" || !e.Next.Equal(next) {
+			t.Fatalf("entry garbled: %s", e)
+		} else if recurring := e.Interval == 5*time.Minute; recurring != (e.ID == "recurring") {
+			t.Fatalf("unexpected interval of %s", e)
+		}
+	}
+}
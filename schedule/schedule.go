@@ -0,0 +1,40 @@
+// Package schedule persists the commands scheduled for delayed or periodic delivery on the bus.
+package schedule
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+)
+
+// Entry is a command scheduled for delivery.
+type Entry struct {
+	ID       string             // Unique ID of the schedule
+	Command  *aggregate.Command // Command to deliver
+	Next     time.Time          // Time of the next delivery
+	Interval time.Duration      // Time between deliveries of a recurring schedule (0: deliver once)
+}
+
+func (e Entry) String() string {
+	if e.Interval > 0 {
+		return fmt.Sprintf("%s: %s => %s every %s, next at %s", e.ID, e.Command, e.Command.Dest(), e.Interval, e.Next)
+	}
+	return fmt.Sprintf("%s: %s => %s at %s", e.ID, e.Command, e.Command.Dest(), e.Next)
+}
+
+// Store persists schedule entries, so that they survive a restart of the bus.
+type Store interface {
+	// Put adds @e, or replaces the entry with the same ID.
+	Put(e Entry) error
+
+	// Delete removes the entry @id, if present.
+	Delete(id string) error
+
+	// List returns all entries, in no particular order. Entries which can not be read back
+	// (e.g. since their command type is not registered) are skipped, rather than failing the listing.
+	List() ([]Entry, error)
+
+	// Close releases the resources held by the Store
+	Close() error
+}
@@ -0,0 +1,196 @@
+package magicbus
+
+import (
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/fault"
+	"github.com/grrtrr/magicbus/schedule"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ScheduleID identifies a scheduled command delivery.
+type ScheduleID string
+
+// scheduled is a pending command delivery.
+type scheduled struct {
+	schedule.Entry
+
+	// Triggers the next delivery
+	timer *time.Timer
+}
+
+// SubmitAt submits @cmd to the local bus at time @at.
+func SubmitAt(cmd *aggregate.Command, at time.Time) (ScheduleID, error) {
+	return localBus.SubmitAt(cmd, at)
+}
+
+// SubmitAfter submits @cmd to the local bus after @d.
+func SubmitAfter(cmd *aggregate.Command, d time.Duration) (ScheduleID, error) {
+	return localBus.SubmitAfter(cmd, d)
+}
+
+// SubmitEvery submits a copy of @cmd to the local bus every @interval.
+func SubmitEvery(cmd *aggregate.Command, interval time.Duration) (ScheduleID, error) {
+	return localBus.SubmitEvery(cmd, interval)
+}
+
+// CancelSchedule cancels the scheduled delivery @id on the local bus.
+func CancelSchedule(id ScheduleID) error {
+	return localBus.CancelSchedule(id)
+}
+
+// SubmitAt submits @cmd to @m at time @at, via the normal routing (see Submit).
+// If a schedule store is configured (see WithScheduleStore), the schedule survives a restart of @m;
+// deliveries missed while the bus was down are made up for right after the restart.
+func (m *MagicBus) SubmitAt(cmd *aggregate.Command, at time.Time) (ScheduleID, error) {
+	return m.schedule(cmd, at, 0)
+}
+
+// SubmitAfter submits @cmd to @m after @d (see SubmitAt).
+func (m *MagicBus) SubmitAfter(cmd *aggregate.Command, d time.Duration) (ScheduleID, error) {
+	return m.schedule(cmd, time.Now().Add(d), 0)
+}
+
+// SubmitEvery submits @cmd to @m every @interval, starting after the first @interval (see SubmitAt).
+// Each delivery is a copy of @cmd with a command ID of its own; missed deliveries are skipped.
+func (m *MagicBus) SubmitEvery(cmd *aggregate.Command, interval time.Duration) (ScheduleID, error) {
+	if interval <= 0 {
+		return "", fault.New(fault.Rejected, "invalid interval %s for recurring %s", interval, cmd)
+	}
+	return m.schedule(cmd, time.Now().Add(interval), interval)
+}
+
+// CancelSchedule cancels the scheduled delivery @id on @m.
+func (m *MagicBus) CancelSchedule(id ScheduleID) error {
+	m.scheduleMu.Lock()
+	defer m.scheduleMu.Unlock()
+
+	if err := <-m.Action(func() error {
+		s, ok := m.schedules[string(id)]
+		if !ok {
+			return fault.New(fault.NotFound, "no schedule %s", id)
+		}
+		s.timer.Stop()
+		delete(m.schedules, s.ID)
+		return nil
+	}); err != nil {
+		return err
+	}
+	return m.unpersistSchedule(string(id))
+}
+
+// schedule delivers @cmd at @next, and every @interval afterwards if @interval > 0.
+func (m *MagicBus) schedule(cmd *aggregate.Command, next time.Time, interval time.Duration) (ScheduleID, error) {
+	if cmd == nil {
+		return "", fault.New(fault.Rejected, "attempt to schedule a nil command")
+	}
+	var e = schedule.Entry{ID: uuid.NewV1().String(), Command: cmd, Next: next, Interval: interval}
+
+	m.scheduleMu.Lock()
+	defer m.scheduleMu.Unlock()
+
+	if m.scheduleStore != nil {
+		if err := m.scheduleStore.Put(e); err != nil {
+			return "", fault.Wrapf(err, fault.Unavailable, "failed to store schedule of %s", cmd)
+		}
+	}
+	if err := <-m.Action(func() error {
+		m.arm(e)
+		return nil
+	}); err != nil {
+		m.unpersistSchedule(e.ID) // not scheduled, hence not to be restored either
+		return "", err
+	}
+	return ScheduleID(e.ID), nil
+}
+
+// restoreSchedules re-arms the schedules persisted in the schedule store of @m.
+func (m *MagicBus) restoreSchedules() {
+	entries, err := m.scheduleStore.List()
+	if err != nil {
+		logger.Errorf("magicbus: failed to restore schedules: %s", err)
+		return
+	}
+
+	if err = <-m.Action(func() error {
+		for _, e := range entries {
+			m.arm(e)
+		}
+		return nil
+	}); err != nil {
+		logger.Errorf("magicbus: failed to restore schedules: %s", err)
+	}
+	logger.Debugf("magicbus: restored %d schedules", len(entries))
+}
+
+// arm sets the timer for the next delivery of @e. Must be called from within the actor loop of @m.
+func (m *MagicBus) arm(e schedule.Entry) {
+	var s = &scheduled{Entry: e}
+
+	s.timer = time.AfterFunc(time.Until(e.Next), func() { m.fire(s) })
+	m.schedules[e.ID] = s
+}
+
+// fire delivers the command of @s, and schedules the next delivery if recurring.
+func (m *MagicBus) fire(s *scheduled) {
+	var cmd *aggregate.Command
+	var next *schedule.Entry
+
+	m.scheduleMu.Lock()
+	err := <-m.Action(func() error {
+		if m.schedules[s.ID] != s { // canceled in the meantime
+			return nil
+		} else if s.Interval <= 0 {
+			cmd = s.Command
+			delete(m.schedules, s.ID)
+			return nil
+		}
+
+		// Each delivery is a new command, so that the results can be told apart.
+		c, err := aggregate.NewCommand(s.Command.Source(), s.Command.Dest(), s.Command.Data())
+		if err != nil {
+			return err
+		}
+		cmd = c.WithMetadata(s.Command.Metadata())
+
+		e := s.Entry
+		for e.Next = e.Next.Add(e.Interval); !e.Next.After(time.Now()); e.Next = e.Next.Add(e.Interval) {
+		}
+		m.arm(e)
+		next = &e
+		return nil
+	})
+
+	if err == nil && next != nil && m.scheduleStore != nil {
+		err = m.scheduleStore.Put(*next)
+	} else if err == nil && cmd != nil {
+		err = m.unpersistSchedule(s.ID)
+	}
+	m.scheduleMu.Unlock()
+
+	if err != nil && m.IsActive() {
+		logger.Errorf("magicbus: schedule %s: %s", s.Entry, err)
+	}
+	if cmd != nil {
+		if err = m.Submit(cmd); err != nil {
+			logger.Errorf("magicbus: failed to submit scheduled %s: %s", cmd, err)
+		}
+	}
+}
+
+// stopSchedules stops the timers of all pending deliveries of @m, leaving the schedule store as is.
+// Must be called from within the actor loop of @m.
+func (m *MagicBus) stopSchedules() {
+	for _, s := range m.schedules {
+		s.timer.Stop()
+	}
+}
+
+// unpersistSchedule removes schedule @id from the schedule store of @m, if any.
+func (m *MagicBus) unpersistSchedule(id string) error {
+	if m.scheduleStore == nil {
+		return nil
+	}
+	return m.scheduleStore.Delete(id)
+}
@@ -0,0 +1,219 @@
+package magicbus
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/schedule"
+)
+
+func TestScheduledCommands(t *testing.T) {
+	var id = aggregate.NewID(aggregate.ResourceType_CPU, "scheduled")
+
+	m := NewMagicBus(context.Background())
+	defer m.Shutdown()
+
+	if err := m.Register(&counterAggregate{id: id}, true); err != nil {
+		t.Fatalf("failed to register %s: %s", id, err)
+	}
+	done := make(chan *event.CommandDone, 10)
+	if _, err := m.Subscribe(func(e event.Event) { done <- e.(*event.CommandDone) }, ByEventType(&event.CommandDone{})); err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+
+	// One-shot delivery
+	cmd, _ := aggregate.NewLocalCommand(id, "increment")
+	start := time.Now()
+	if _, err := m.SubmitAfter(cmd, 50*time.Millisecond); err != nil {
+		t.Fatalf("failed to schedule %s: %s", cmd, err)
+	}
+	select {
+	case cd := <-done:
+		if cd.CmdID != cmd.ID() {
+			t.Fatalf("unexpected CommandDone %s", cd)
+		} else if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Fatalf("%s delivered early, after %s", cmd, elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("scheduled %s was not delivered", cmd)
+	}
+
+	// A canceled delivery does not happen.
+	sid, err := m.SubmitAfter(cmd, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to schedule %s: %s", cmd, err)
+	} else if err = m.CancelSchedule(sid); err != nil {
+		t.Fatalf("failed to cancel %s: %s", sid, err)
+	} else if err = m.CancelSchedule(sid); err == nil {
+		t.Fatalf("expected canceling %s twice to fail", sid)
+	}
+
+	// Recurring delivery, with a new command ID each time.
+	if _, err = m.SubmitEvery(cmd, 0); err == nil {
+		t.Fatalf("expected an invalid interval to fail")
+	}
+	sid, err = m.SubmitEvery(cmd, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to schedule %s: %s", cmd, err)
+	}
+
+	var seen = map[string]bool{}
+	for len(seen) < 3 {
+		select {
+		case cd := <-done:
+			if seen[cd.CmdID] {
+				t.Fatalf("command ID %s delivered twice", cd.CmdID)
+			}
+			seen[cd.CmdID] = true
+		case <-time.After(time.Second):
+			t.Fatalf("recurring %s was not delivered", cmd)
+		}
+	}
+	if err = m.CancelSchedule(sid); err != nil {
+		t.Fatalf("failed to cancel %s: %s", sid, err)
+	}
+
+	// Drain deliveries that were under way when canceling.
+	for drained := false; !drained; {
+		select {
+		case <-done:
+		case <-time.After(100 * time.Millisecond):
+			drained = true
+		}
+	}
+}
+
+func TestScheduleStore(t *testing.T) {
+	var id = aggregate.NewID(aggregate.ResourceType_CPU, "scheduled")
+
+	dir, err := os.MkdirTemp("", "magicbus")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "schedules")
+	store, err := schedule.OpenFile(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", path, err)
+	}
+
+	// Schedule a command, then shut down the bus before it is delivered.
+	m := NewMagicBus(context.Background(), WithScheduleStore(store))
+	cmd, _ := aggregate.NewLocalCommand(id, "increment")
+	if _, err = m.SubmitAfter(cmd, 200*time.Millisecond); err != nil {
+		t.Fatalf("failed to schedule %s: %s", cmd, err)
+	}
+	m.Shutdown()
+	store.Close()
+
+	// The restarted bus picks up the schedule.
+	if store, err = schedule.OpenFile(path); err != nil {
+		t.Fatalf("failed to re-open %s: %s", path, err)
+	}
+	defer store.Close()
+
+	m = NewMagicBus(context.Background(), WithScheduleStore(store))
+	defer m.Shutdown()
+
+	if err := m.Register(&counterAggregate{id: id}, true); err != nil {
+		t.Fatalf("failed to register %s: %s", id, err)
+	}
+	done := make(chan *event.CommandDone, 1)
+	if _, err := m.Subscribe(func(e event.Event) { done <- e.(*event.CommandDone) }, ByEventType(&event.CommandDone{})); err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+
+	select {
+	case cd := <-done:
+		if cd.CmdID != cmd.ID() {
+			t.Fatalf("unexpected CommandDone %s", cd)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("scheduled %s was not delivered after restart", cmd)
+	}
+
+	if entries, err := store.List(); err != nil {
+		t.Fatalf("failed to list schedules: %s", err)
+	} else if len(entries) != 0 {
+		t.Fatalf("expected delivered schedule to be removed, got %v", entries)
+	}
+}
+
+func TestSlowScheduleStore(t *testing.T) {
+	var id = aggregate.NewID(aggregate.ResourceType_CPU, "scheduled")
+
+	dir, err := os.MkdirTemp("", "magicbus")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs, err := schedule.OpenFile(filepath.Join(dir, "schedules"))
+	if err != nil {
+		t.Fatalf("failed to open schedule store: %s", err)
+	}
+	defer fs.Close()
+
+	var store = &slowStore{Store: fs, entered: make(chan struct{}, 1), release: make(chan struct{})}
+	m := NewMagicBus(context.Background(), WithScheduleStore(store))
+	defer m.Shutdown()
+
+	// The bus keeps serving requests while the store is busy.
+	var scheduled = make(chan error, 1)
+	go func() {
+		cmd, _ := aggregate.NewLocalCommand(id, "increment")
+		_, err := m.SubmitAfter(cmd, time.Hour)
+		scheduled <- err
+	}()
+
+	<-store.entered
+	var served = make(chan string, 1)
+	go func() { served <- m.String() }()
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatalf("bus blocked by the schedule store")
+	}
+
+	close(store.release)
+	if err := <-scheduled; err != nil {
+		t.Fatalf("failed to schedule: %s", err)
+	}
+
+	// Shutting down stops the timers of pending deliveries, but keeps them in the store.
+	if err := m.Shutdown(); err != nil {
+		t.Fatalf("failed to shut down: %s", err)
+	} else if len(m.schedules) != 1 {
+		t.Fatalf("expected 1 pending schedule, got %d", len(m.schedules))
+	}
+	for _, s := range m.schedules {
+		if s.timer.Stop() {
+			t.Fatalf("timer of %s still running after shutdown", s.Entry)
+		}
+	}
+	if entries, err := store.List(); err != nil || len(entries) != 1 {
+		t.Fatalf("expected the schedule to be retained, got %v (%v)", entries, err)
+	}
+}
+
+// slowStore is a schedule.Store whose Put signals @entered, then blocks until @release is closed.
+type slowStore struct {
+	schedule.Store
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStore) Put(e schedule.Entry) error {
+	select {
+	case s.entered <- struct{}{}:
+	default:
+	}
+	<-s.release
+	return s.Store.Put(e)
+}
@@ -0,0 +1,45 @@
+package magicbus
+
+import (
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/eventstore"
+)
+
+// storedEvent marks an event which has already been persisted, e.g. by an event-sourced Aggregate.
+type storedEvent struct {
+	event.Event
+}
+
+// persist appends @e to the stream of its source Aggregate, if an event store is configured.
+// Bus-internal notifications (CommandDone, ServiceReady) are not persisted, nor are events
+// published by others on behalf of an event-sourced Aggregate, which owns its stream.
+// Must be called from within the actor loop of @m.
+func (m *MagicBus) persist(e event.Event) error {
+	switch e.(type) {
+	case *event.CommandDone, *event.ServiceReady:
+		return nil
+	}
+	if m.store == nil {
+		return nil
+	} else if m.sourced(e.Source()) {
+		logger.Warningf("magicbus: not persisting %v - the stream of %s is owned by its aggregate", e, e.Source())
+		return nil
+	}
+
+	_, err := m.store.Append(e.Source(), eventstore.AnyVersion, e)
+	return err
+}
+
+// sourced returns true if the stream of @id belongs to an event-sourced Aggregate, which is registered,
+// passivated, or can be created by a factory. Must be called from within the actor loop of @m.
+func (m *MagicBus) sourced(id aggregate.ID) bool {
+	if ag, ok := m.aggregates[id]; ok {
+		return ag.sourced
+	} else if p, ok := m.passivated.get(id); ok {
+		return p.sourcedFactory != nil
+	} else if f, ok := m.factories[id.Type]; ok && id.ID != "" {
+		return f.sourcedFactory != nil
+	}
+	return false
+}
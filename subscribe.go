@@ -1,7 +1,16 @@
 package magicbus
 
 import (
+	"context"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/grrtrr/magicbus/actor"
+	"github.com/grrtrr/magicbus/aggregate"
 	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
 	uuid "github.com/satori/go.uuid"
 )
 
@@ -21,30 +30,286 @@ func (s SubscriptionID) IsZero() bool {
 	return uuid.Equal(uuid.UUID(s), uuid.Nil)
 }
 
+// Subscribe subscribes @hdlr to the events on the local bus which pass all filters @opts.
+func Subscribe(hdlr event.Handler, opts ...SubscribeOption) (SubscriptionID, error) {
+	return localBus.Subscribe(hdlr, opts...)
+}
+
 // Observer subscribes @hdlr to receive immediate notification of events.
 func Observer(hdlr event.Handler) (SubscriptionID, error) {
-	return localBus.observer(hdlr)
+	return localBus.Observer(hdlr)
 }
 
 // Unsubscribe removes subscription @id from the local bus.
 func Unsubscribe(id SubscriptionID) error {
-	return localBus.unsubscribe(id)
+	return localBus.Unsubscribe(id)
+}
+
+// subscription delivers the events which pass all of its filters to its handler.
+type subscription struct {
+	handler event.Handler
+	filters []func(event.Event) bool
+
+	// Delivers events one at a time, in publish order (nil if concurrent)
+	actor.Actor
+
+	// Whether to deliver each event in a goroutine of its own
+	concurrent bool
+
+	// Number of events after which the subscription ends (0: unlimited)
+	remaining int
+
+	// The subscription ends when @ctx is done, or at @deadline (nil/zero: never)
+	ctx      context.Context
+	deadline time.Time
+	cancel   context.CancelFunc
+
+	// Delivery statistics
+	mu        sync.Mutex
+	inFlight  int
+	delivered uint64
+	lag       time.Duration
+	maxLag    time.Duration
+}
+
+// SubscriptionStats reports the delivery progress of a subscription.
+type SubscriptionStats struct {
+	Pending   int           // Number of events not yet delivered
+	Delivered uint64        // Number of events delivered so far
+	Lag       time.Duration // Time from dispatch by the bus to delivery of the most recent event
+	MaxLag    time.Duration // Maximum Lag so far
+}
+
+// queuedEvent records when an event was queued for delivery.
+type queuedEvent struct {
+	event.Event
+	queued time.Time
+
+	// Whether this is the last event of the subscription
+	last bool
+}
+
+// start sets up the delivery queue of @s, unless delivering concurrently.
+func (s *subscription) start(ctx context.Context) {
+	if !s.concurrent {
+		s.Actor = actor.New(ctx, func(cmd *aggregate.Command) {
+			logger.Errorf("magicbus: subscription refusing to handle %s command", cmd)
+		}, func(e event.Event) {
+			s.deliver(e.(queuedEvent))
+		}, true)
+	}
 }
 
-// Add new observer to @m
-func (m *MagicBus) observer(hdlr event.Handler) (SubscriptionID, error) {
+// dispatch queues @e for delivery, returning true if @e is the last event of @s.
+// Must be called from within the actor loop of the bus.
+func (s *subscription) dispatch(e event.Event) (last bool) {
+	var qe = queuedEvent{Event: e, queued: time.Now()}
+
+	if s.remaining > 0 {
+		s.remaining--
+		qe.last = s.remaining == 0
+	}
+
+	if !s.concurrent {
+		if err := s.Publish(qe); err != nil && err != actor.ErrShutdown {
+			logger.Errorf("magicbus: failed to queue %v for subscriber: %s", e, err)
+		}
+		return qe.last
+	}
+
+	s.mu.Lock()
+	s.inFlight++
+	s.mu.Unlock()
+
+	go s.deliverRecovering(qe)
+	return qe.last
+}
+
+// deliver passes @qe on to the handler of @s, and updates the statistics.
+// The delivery queue of @s shuts down after the last event.
+func (s *subscription) deliver(qe queuedEvent) {
+	if qe.Event != nil { // nil: end-of-subscription marker
+		s.handle(qe)
+	}
+	if qe.last && s.Actor != nil { // the delivery queue is no longer needed
+		s.Shutdown()
+	}
+}
+
+// deliverRecovering is deliver for concurrent subscriptions: like the delivery queue,
+// it recovers from a panicking handler, rather than crashing the process.
+func (s *subscription) deliverRecovering(qe queuedEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("magicbus: subscription handler failed on %v: panic: %v\n%s", qe.Event, r, debug.Stack())
+		}
+	}()
+	s.deliver(qe)
+}
+
+// handle calls the handler of @s on @qe, keeping track of the statistics.
+func (s *subscription) handle(qe queuedEvent) {
+	var lag = time.Since(qe.queued)
+
+	s.mu.Lock()
+	if s.lag = lag; lag > s.maxLag {
+		s.maxLag = lag
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.concurrent {
+			s.inFlight--
+		}
+		s.delivered++
+	}()
+	s.handler(qe.Event)
+}
+
+// stats returns the current delivery statistics of @s.
+func (s *subscription) stats() SubscriptionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := SubscriptionStats{Pending: s.inFlight, Delivered: s.delivered, Lag: s.lag, MaxLag: s.maxLag}
+	if s.Actor != nil {
+		_, stats.Pending = s.QueueDepth()
+	}
+	return stats
+}
+
+// matches returns true if @e passes all filters of @s, evaluated in order.
+// A panicking filter counts as mismatch, since filters run within the loop of the bus.
+func (s *subscription) matches(e event.Event) (match bool) {
+	if s.ctx != nil && s.ctx.Err() != nil { // expired, but not yet removed
+		return false
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("magicbus: subscription filter panicked on %v: %v", e, r)
+			match = false
+		}
+	}()
+
+	for _, filter := range s.filters {
+		if !filter(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// SubscribeOption restricts the events delivered to a subscription.
+// Multiple options must all match for an event to be delivered; they are evaluated in order,
+// i.e. a Where predicate may rely on a preceding ByEventType.
+type SubscribeOption func(*subscription)
+
+// Concurrent delivers each event to the subscription in a goroutine of its own, i.e. not in order.
+// By default, events are delivered one at a time, in the order they were published.
+func Concurrent() SubscribeOption {
+	return func(s *subscription) {
+		s.concurrent = true
+	}
+}
+
+// Where restricts a subscription to the events for which @pred returns true.
+func Where(pred func(event.Event) bool) SubscribeOption {
+	return func(s *subscription) {
+		s.filters = append(s.filters, pred)
+	}
+}
+
+// ByEventType restricts a subscription to events of the same type as one of @examples,
+// e.g. ByEventType(&event.CommandDone{}).
+func ByEventType(examples ...event.Event) SubscribeOption {
+	var types = map[reflect.Type]bool{}
+
+	for _, e := range examples {
+		types[reflect.TypeOf(e)] = true
+	}
+	return Where(func(e event.Event) bool {
+		return types[reflect.TypeOf(e)]
+	})
+}
+
+// BySource restricts a subscription to events originating from Aggregate @id.
+func BySource(id aggregate.ID) SubscribeOption {
+	return Where(func(e event.Event) bool {
+		return e.Source() == id
+	})
+}
+
+// ByDest restricts a subscription to events directed to Aggregate @id.
+func ByDest(id aggregate.ID) SubscribeOption {
+	return Where(func(e event.Event) bool {
+		return e.Dest() == id
+	})
+}
+
+// ByResourceType restricts a subscription to events originating from Aggregates of type @rt.
+func ByResourceType(rt aggregate.ResourceType) SubscribeOption {
+	return Where(func(e event.Event) bool {
+		return e.Source().Type == rt
+	})
+}
+
+// ByNode restricts a subscription to events originating from Aggregates on @node.
+func ByNode(node string) SubscribeOption {
+	return Where(func(e event.Event) bool {
+		return e.Source().Node == node
+	})
+}
+
+// Subscribe subscribes @hdlr to the events on @m which pass all filters @opts.
+// Filters are evaluated by the bus; @hdlr is only called for matching events.
+// Unless Concurrent is given, @hdlr receives the events one at a time, in publish order.
+func (m *MagicBus) Subscribe(hdlr event.Handler, opts ...SubscribeOption) (SubscriptionID, error) {
 	var id = NewSubscriptionID()
+	if hdlr == nil {
+		return id, fault.New(fault.Rejected, "attempt to subscribe a nil event handler")
+	}
+	var sub = &subscription{handler: chainDeliver(m.deliverInterceptors, hdlr)}
+
+	for _, opt := range opts {
+		opt(sub)
+	}
+	sub.start(m.Context())
 
 	return id, <-m.Action(func() error {
-		m.observers[id.String()] = hdlr
+		m.subscriptions[id.String()] = sub
+		m.watchExpiry(id, sub)
 		return nil
 	})
 }
 
-// Remove subscription records of @id
-func (m *MagicBus) unsubscribe(id SubscriptionID) error {
+// Observer subscribes @hdlr to receive immediate notification of all events on @m.
+func (m *MagicBus) Observer(hdlr event.Handler) (SubscriptionID, error) {
+	return m.Subscribe(hdlr)
+}
+
+// Unsubscribe removes subscription @id from @m. Events not yet delivered to it are discarded.
+func (m *MagicBus) Unsubscribe(id SubscriptionID) error {
 	return <-m.Action(func() error {
-		delete(m.observers, id.String())
+		if sub, ok := m.subscriptions[id.String()]; ok {
+			return m.endSubscription(id.String(), sub, true)
+		}
+		return nil
+	})
+}
+
+// SubscriptionStats returns the delivery statistics of subscription @id on @m.
+func (m *MagicBus) SubscriptionStats(id SubscriptionID) (stats SubscriptionStats, err error) {
+	err = <-m.Action(func() error {
+		sub, ok := m.subscriptions[id.String()]
+		if !ok {
+			return fault.New(fault.NotFound, "no subscription %s", id)
+		}
+		stats = sub.stats()
 		return nil
 	})
+	return stats, err
 }
@@ -0,0 +1,216 @@
+package magicbus
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+)
+
+func TestFilteredSubscriptions(t *testing.T) {
+	var cpu = aggregate.NewID(aggregate.ResourceType_CPU, "filtered")
+	var mem = aggregate.NewID(aggregate.ResourceType_MEMORY, "filtered")
+	var remote = aggregate.ID{Node: "otherNode", Type: aggregate.ResourceType_CPU}
+
+	m := NewMagicBus(context.Background())
+	defer m.Shutdown()
+
+	// subscribe returns the channel on which the events matching @opts arrive.
+	subscribe := func(opts ...SubscribeOption) <-chan event.Event {
+		var ch = make(chan event.Event, 10)
+
+		if _, err := m.Subscribe(func(e event.Event) { ch <- e }, opts...); err != nil {
+			t.Fatalf("failed to subscribe: %s", err)
+		}
+		return ch
+	}
+
+	var (
+		byType   = subscribe(ByEventType(&event.ServiceReady{}))
+		bySource = subscribe(BySource(mem))
+		byDest   = subscribe(ByDest(mem))
+		byRT     = subscribe(ByResourceType(aggregate.ResourceType_CPU), ByNode(aggregate.NodeID()))
+		where    = subscribe(ByEventType(&testEvent{}),
+			Where(func(e event.Event) bool { return e.(*testEvent).kind == "wanted" }))
+	)
+
+	events := []event.Event{
+		mkTestEvent(cpu, aggregate.ID{}, "wanted"),
+		mkTestEvent(mem, cpu, "from memory"),
+		mkTestEvent(remote, mem, "to memory"),
+		&event.ServiceReady{Aggregate: aggregate.NewID(aggregate.ResourceType_INVALID_RESOURCE, "")},
+	}
+	for _, e := range events {
+		if err := m.Publish(e); err != nil {
+			t.Fatalf("failed to publish %v: %s", e, err)
+		}
+	}
+
+	// Each subscriber receives exactly the events matching its filters.
+	for _, tc := range []struct {
+		name     string
+		ch       <-chan event.Event
+		expected []event.Event
+	}{
+		{"ByEventType", byType, events[3:]},
+		{"BySource", bySource, events[1:2]},
+		{"ByDest", byDest, events[2:3]},
+		{"ByResourceType+ByNode", byRT, events[0:1]},
+		{"Where", where, events[0:1]},
+	} {
+		for _, expected := range tc.expected {
+			select {
+			case e := <-tc.ch:
+				if e != expected {
+					t.Fatalf("%s: expected %v, got %v", tc.name, expected, e)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s: timed out waiting for %v", tc.name, expected)
+			}
+		}
+
+		select {
+		case e := <-tc.ch:
+			t.Fatalf("%s: received unexpected %v", tc.name, e)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestOrderedDelivery(t *testing.T) {
+	const numEvents = 200
+	var src = aggregate.NewID(aggregate.ResourceType_CPU, "ordered")
+
+	m := NewMagicBus(context.Background())
+	defer m.Shutdown()
+
+	var ordered = make(chan string, numEvents)
+	orderedID, err := m.Subscribe(func(e event.Event) { ordered <- e.(*testEvent).kind })
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+
+	// A panicking concurrent handler does not affect the delivery of other events.
+	var concurrent = make(chan string, numEvents)
+	concurrentID, err := m.Subscribe(func(e event.Event) {
+		if concurrent <- e.(*testEvent).kind; e.(*testEvent).kind == "0" {
+			panic("concurrent handler failed")
+		}
+	}, Concurrent())
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+
+	for i := 0; i < numEvents; i++ {
+		if err := m.Publish(mkTestEvent(src, src, fmt.Sprint(i))); err != nil {
+			t.Fatalf("failed to publish event #%d: %s", i, err)
+		}
+	}
+
+	// The ordered subscriber sees the events in publish order, the concurrent one sees all of them.
+	for i := 0; i < numEvents; i++ {
+		select {
+		case kind := <-ordered:
+			if kind != fmt.Sprint(i) {
+				t.Fatalf("out-of-order delivery: expected #%d, got #%s", i, kind)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for event #%d", i)
+		}
+
+		select {
+		case <-concurrent:
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for concurrent delivery #%d", i)
+		}
+	}
+
+	for _, id := range []SubscriptionID{orderedID, concurrentID} {
+		// The statistics are updated after the handler returns.
+		for start := time.Now(); ; time.Sleep(time.Millisecond) {
+			stats, err := m.SubscriptionStats(id)
+			if err != nil {
+				t.Fatalf("failed to get statistics of %s: %s", id, err)
+			} else if stats.Delivered == numEvents && stats.Pending == 0 && stats.MaxLag >= stats.Lag {
+				break
+			} else if time.Since(start) > time.Second {
+				t.Fatalf("unexpected statistics of %s: %+v", id, stats)
+			}
+		}
+	}
+}
+
+func TestExpiringSubscriptions(t *testing.T) {
+	var src = aggregate.NewID(aggregate.ResourceType_CPU, "expiring")
+
+	m := NewMagicBus(context.Background())
+	defer m.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var counted, timed, bound = make(chan event.Event, 10), make(chan event.Event, 10), make(chan event.Event, 10)
+
+	countedID, _ := m.Subscribe(func(e event.Event) { counted <- e }, Count(2))
+	timedID, _ := m.Subscribe(func(e event.Event) { timed <- e }, Until(time.Now().Add(200*time.Millisecond)))
+	boundID, _ := m.Subscribe(func(e event.Event) { bound <- e }, UntilDone(ctx))
+
+	publish := func(n int) {
+		for i := 0; i < n; i++ {
+			if err := m.Publish(mkTestEvent(src, src, "expiring")); err != nil {
+				t.Fatalf("failed to publish: %s", err)
+			}
+		}
+	}
+
+	// awaitRemoval waits until subscription @id has been reclaimed by the bus.
+	awaitRemoval := func(id SubscriptionID) {
+		for start := time.Now(); ; time.Sleep(5 * time.Millisecond) {
+			if _, err := m.SubscriptionStats(id); err != nil {
+				return
+			} else if time.Since(start) > time.Second {
+				t.Fatalf("subscription %s was not removed", id)
+			}
+		}
+	}
+
+	publish(3)
+	awaitRemoval(countedID)
+
+	// Cancel only once all events have been processed by the bus.
+	for start := time.Now(); len(bound) < 3; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("timed out waiting for events")
+		}
+	}
+	cancel()
+	awaitRemoval(boundID)
+	awaitRemoval(timedID)
+
+	// A subscription which has expired before registration is removed as well.
+	expiredID, _ := m.Subscribe(func(e event.Event) { bound <- e }, UntilDone(ctx))
+	awaitRemoval(expiredID)
+
+	// Events published afterwards are not delivered to any of the subscriptions.
+	publish(1)
+	time.Sleep(10 * time.Millisecond)
+
+	// The count of a subscription must be positive.
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected Count(0) to panic")
+			}
+		}()
+		Count(0)
+	}()
+
+	if len(counted) != 2 {
+		t.Fatalf("expected 2 events for Count(2), got %d", len(counted))
+	} else if len(timed) != 3 {
+		t.Fatalf("expected 3 events before deadline, got %d", len(timed))
+	} else if len(bound) != 3 {
+		t.Fatalf("expected 3 events before cancellation, got %d", len(bound))
+	}
+}
@@ -0,0 +1,49 @@
+package trace
+
+import "sync"
+
+// InMemoryExporter retains all exported spans, e.g. for inspection in tests.
+type InMemoryExporter struct {
+	sync.Mutex
+	spans []SpanData
+}
+
+// NewInMemoryExporter returns an empty InMemoryExporter.
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) Export(s SpanData) {
+	e.Lock()
+	defer e.Unlock()
+
+	e.spans = append(e.spans, s)
+}
+
+// Spans returns the spans exported so far, in order of completion.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.Lock()
+	defer e.Unlock()
+
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Trace returns the exported spans of trace @traceID, in order of completion.
+func (e *InMemoryExporter) Trace(traceID string) []SpanData {
+	var spans []SpanData
+
+	for _, s := range e.Spans() {
+		if s.Context.TraceID == traceID {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// Reset discards the spans exported so far.
+func (e *InMemoryExporter) Reset() {
+	e.Lock()
+	defer e.Unlock()
+
+	e.spans = nil
+}
@@ -0,0 +1,148 @@
+// Package trace defines the tracing hooks of the bus: a pluggable Tracer which starts Spans,
+// and the propagation of span contexts through Go contexts and command/event metadata.
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID string // 16 bytes, hex-encoded
+	SpanID  string // 8 bytes, hex-encoded
+}
+
+// IsValid returns true if @sc identifies a span.
+func (sc SpanContext) IsValid() bool {
+	return validID(sc.TraceID, 16) && validID(sc.SpanID, 8)
+}
+
+// String returns @sc in the format of a W3C 'traceparent' header.
+func (sc SpanContext) String() string {
+	return fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID)
+}
+
+// ParseSpanContext parses @s in the format of a W3C 'traceparent' header (see SpanContext.String).
+func ParseSpanContext(s string) (SpanContext, error) {
+	var f = strings.Split(s, "-")
+
+	if len(f) != 4 || f[0] != "00" {
+		return SpanContext{}, fmt.Errorf("invalid trace parent %q", s)
+	} else if sc := (SpanContext{TraceID: f[1], SpanID: f[2]}); sc.IsValid() {
+		return sc, nil
+	}
+	return SpanContext{}, fmt.Errorf("invalid trace parent %q", s)
+}
+
+// validID returns true if @id is the hex encoding of @n bytes, not all of them zero.
+func validID(id string, n int) bool {
+	b, err := hex.DecodeString(id)
+	if err != nil || len(b) != n {
+		return false
+	}
+	for _, c := range b {
+		if c != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Span is a timed operation within a trace.
+type Span interface {
+	// Context returns the SpanContext of the span (not valid if the span is not recorded).
+	Context() SpanContext
+
+	// SetAttribute annotates the span with @key = @value.
+	SetAttribute(key, value string)
+
+	// SetError marks the span as failed with @err, unless @err is nil.
+	SetError(err error)
+
+	// End completes the span. Calls after the first one have no effect.
+	End()
+}
+
+// Tracer starts Spans, e.g. by adapting an OpenTelemetry tracer.
+type Tracer interface {
+	// Start starts span @name as child of @parent. If @parent is not valid, the span starts a new trace.
+	Start(parent SpanContext, name string, opts ...StartOption) Span
+}
+
+// StartConfig collects the settings of a span passed to Tracer.Start.
+type StartConfig struct {
+	// Start time of the span (zero: now)
+	Time time.Time
+}
+
+// StartOption configures a span passed to Tracer.Start.
+type StartOption func(*StartConfig)
+
+// WithStartTime starts the span at @t instead of now, e.g. for spans measured after the fact.
+func WithStartTime(t time.Time) StartOption {
+	return func(c *StartConfig) {
+		c.Time = t
+	}
+}
+
+// NewStartConfig returns the StartConfig set by @opts.
+func NewStartConfig(opts ...StartOption) StartConfig {
+	var c StartConfig
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.Time.IsZero() {
+		c.Time = time.Now()
+	}
+	return c
+}
+
+// NoopSpan is a Span which is not recorded.
+type NoopSpan struct{}
+
+func (NoopSpan) Context() SpanContext     { return SpanContext{} }
+func (NoopSpan) SetAttribute(_, _ string) {}
+func (NoopSpan) SetError(error)           {}
+func (NoopSpan) End()                     {}
+
+/*
+ * Propagation
+ */
+// spanKey is the context key of a SpanContext.
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of @ctx which carries @sc, making spans started by the bus on behalf
+// of @ctx children of @sc, e.g. when passed to Launch, or attached to a command:
+//
+//	cmd.WithContext(trace.ContextWithSpan(ctx, span.Context()))
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// FromContext returns the SpanContext carried by @ctx (not valid if none).
+func FromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanKey{}).(SpanContext)
+	return sc
+}
+
+// ParentKey is the metadata key which carries the SpanContext of a command or event across buses.
+const ParentKey = "traceparent"
+
+// Inject returns the metadata which carries @sc (nil if @sc is not valid), to be added to a command.
+func Inject(sc SpanContext) map[string]string {
+	if !sc.IsValid() {
+		return nil
+	}
+	return map[string]string{ParentKey: sc.String()}
+}
+
+// Extract returns the SpanContext carried by metadata @md (not valid if none).
+func Extract(md map[string]string) SpanContext {
+	sc, _ := ParseSpanContext(md[ParentKey])
+	return sc
+}
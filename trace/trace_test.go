@@ -0,0 +1,71 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPropagation(t *testing.T) {
+	var sc = SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}
+
+	if parsed, err := ParseSpanContext(sc.String()); err != nil || parsed != sc {
+		t.Fatalf("failed to parse %s: %v, %s", sc, parsed, err)
+	} else if got := Extract(Inject(sc)); got != sc {
+		t.Fatalf("expected %s from metadata, got %s", sc, got)
+	} else if got := FromContext(ContextWithSpan(context.Background(), sc)); got != sc {
+		t.Fatalf("expected %s from context, got %s", sc, got)
+	}
+
+	for _, s := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba9-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bx-01",
+	} {
+		if sc, err := ParseSpanContext(s); err == nil {
+			t.Fatalf("expected %q to be rejected, got %s", s, sc)
+		}
+	}
+
+	if Inject(SpanContext{}) != nil || Extract(nil).IsValid() || FromContext(context.Background()).IsValid() {
+		t.Fatalf("expected the zero SpanContext not to be propagated")
+	}
+}
+
+func TestTracer(t *testing.T) {
+	var exporter = NewInMemoryExporter()
+	var tracer = NewTracer(exporter)
+	var failure = errors.New("failed")
+	var start = time.Now().Add(-time.Second)
+
+	root := tracer.Start(SpanContext{}, "root")
+	child := tracer.Start(root.Context(), "child", WithStartTime(start))
+	child.SetAttribute("key", "value")
+	child.SetError(failure)
+	child.End()
+	child.End()
+	root.End()
+	child.SetAttribute("key", "changed")
+
+	spans := exporter.Trace(root.Context().TraceID)
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %v", spans)
+	}
+
+	if c := spans[0]; c.Name != "child" || c.Parent != root.Context() || c.Context.TraceID != root.Context().TraceID {
+		t.Fatalf("unexpected child span %+v", c)
+	} else if c.Attributes["key"] != "value" || c.Err != failure || c.Start != start || c.Duration() < time.Second {
+		t.Fatalf("unexpected child span %+v", c)
+	}
+	if r := spans[1]; r.Name != "root" || r.Parent.IsValid() || !r.Context.IsValid() || r.Err != nil {
+		t.Fatalf("unexpected root span %+v", r)
+	}
+
+	exporter.Reset()
+	if spans := exporter.Spans(); len(spans) != 0 {
+		t.Fatalf("expected no spans after reset, got %v", spans)
+	}
+}
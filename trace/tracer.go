@@ -0,0 +1,111 @@
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// SpanData is a completed span, as passed to an Exporter.
+type SpanData struct {
+	Name       string
+	Context    SpanContext
+	Parent     SpanContext // not valid for the root span of a trace
+	Start, End time.Time
+	Attributes map[string]string
+	Err        error
+}
+
+// Duration returns the time taken by the operation of @s.
+func (s SpanData) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// Exporter receives the spans of a Tracer as they complete, e.g. to send them to a tracing backend.
+type Exporter interface {
+	Export(SpanData)
+}
+
+// NewTracer returns a Tracer which records spans and passes them to @exp once they end.
+func NewTracer(exp Exporter) Tracer {
+	return &tracer{exporter: exp}
+}
+
+// tracer is the recording Tracer returned by NewTracer.
+type tracer struct {
+	exporter Exporter
+}
+
+func (t *tracer) Start(parent SpanContext, name string, opts ...StartOption) Span {
+	var sc = SpanContext{TraceID: parent.TraceID, SpanID: newID(8)}
+
+	if !parent.IsValid() {
+		sc.TraceID, parent = newID(16), SpanContext{}
+	}
+	return &span{
+		exporter: t.exporter,
+		data: SpanData{
+			Name:       name,
+			Context:    sc,
+			Parent:     parent,
+			Start:      NewStartConfig(opts...).Time,
+			Attributes: map[string]string{},
+		},
+	}
+}
+
+// span is a Span recorded by tracer.
+type span struct {
+	sync.Mutex
+
+	exporter Exporter
+	data     SpanData
+	ended    bool
+}
+
+func (s *span) Context() SpanContext {
+	return s.data.Context
+}
+
+func (s *span) SetAttribute(key, value string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+}
+
+func (s *span) SetError(err error) {
+	if err != nil {
+		s.Lock()
+		defer s.Unlock()
+
+		if !s.ended {
+			s.data.Err = err
+		}
+	}
+}
+
+func (s *span) End() {
+	s.Lock()
+	if s.ended {
+		s.Unlock()
+		return
+	}
+	s.ended, s.data.End = true, time.Now()
+	s.Unlock()
+
+	s.exporter.Export(s.data)
+}
+
+// newID returns a random hex-encoded ID of @n bytes.
+func newID(n int) string {
+	var b = make([]byte, n)
+
+	if _, err := rand.Read(b); err != nil {
+		panic("failed to generate span ID: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
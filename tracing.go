@@ -0,0 +1,74 @@
+package magicbus
+
+import (
+	"context"
+	"time"
+
+	"github.com/grrtrr/magicbus/actor"
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/codec"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/trace"
+)
+
+/*
+ * Tracing (see WithTracer). The bus records the following spans of a command:
+ *
+ *   launch <type>          Launch, until the command and its follow-up commands have completed
+ *   `- submit <type>       Submit, until the command is queued (locally or on a remote bus)
+ *      |- queue <type>     time spent waiting in the mailbox of the bus, and of the Aggregate
+ *      |- route <type>     lookup of the Aggregate, and hand-off to its mailbox
+ *      |- handle <type>    HandleCommand, including the interceptors
+ *      |  `- launch ...    follow-up commands
+ *      `- dispatch <event> delivery of the events caused by the command to their observers
+ *
+ * The submit span travels with the command in its metadata (see trace.Inject), so that
+ * the trace continues on remote buses, and in the events caused by the command.
+ */
+
+// startSpan starts span @name as child of @parent (a span which is not recorded if tracing is disabled).
+func (m *MagicBus) startSpan(parent trace.SpanContext, name string, opts ...trace.StartOption) trace.Span {
+	if m.tracer == nil {
+		return trace.NoopSpan{}
+	}
+	return m.tracer.Start(parent, name, opts...)
+}
+
+// startCommandSpan starts span @name of @cmd as child of @parent.
+func (m *MagicBus) startCommandSpan(parent trace.SpanContext, cmd *aggregate.Command, name string, opts ...trace.StartOption) trace.Span {
+	span := m.startSpan(parent, name+" "+cmd.Type(), opts...)
+	span.SetAttribute("command.id", cmd.ID())
+	span.SetAttribute("command.dest", cmd.Dest().String())
+	return span
+}
+
+// submitParent returns the parent of the spans of Launch/Submit of @cmd: the span in @ctx,
+// in the context of @cmd, or in the metadata of @cmd, in this order.
+func submitParent(ctx context.Context, cmd *aggregate.Command) trace.SpanContext {
+	for _, sc := range []trace.SpanContext{trace.FromContext(ctx), trace.FromContext(cmd.Context())} {
+		if sc.IsValid() {
+			return sc
+		}
+	}
+	return trace.Extract(cmd.Metadata())
+}
+
+// startEventSpan starts span @name of @e, as child of the span in the metadata of @e.
+func (m *MagicBus) startEventSpan(e event.Event, name string) trace.Span {
+	span := m.startSpan(trace.Extract(event.MetadataOf(e)), name+" "+codec.EventType(e))
+	if !e.Dest().IsZero() {
+		span.SetAttribute("event.dest", e.Dest().String())
+	}
+	return span
+}
+
+// queueTiming returns the actor option which records the time commands spend in mailbox @mailbox.
+func (m *MagicBus) queueTiming(mailbox string) actor.Option {
+	return actor.WithQueueTiming(func(msg interface{}, enqueued time.Time) {
+		if cmd, ok := msg.(*aggregate.Command); ok {
+			span := m.startCommandSpan(trace.Extract(cmd.Metadata()), cmd, "queue", trace.WithStartTime(enqueued))
+			span.SetAttribute("mailbox", mailbox)
+			span.End()
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package magicbus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/fault"
+	"github.com/grrtrr/magicbus/trace"
+	"github.com/grrtrr/magicbus/transport"
+)
+
+func TestTracing(t *testing.T) {
+	var network = transport.NewLoopback()
+	var exporter = trace.NewInMemoryExporter()
+	var tracer = trace.NewTracer(exporter)
+	var id = aggregate.NewID(aggregate.ResourceType_CPU, "traced")
+	var planner = &plannerAggregate{id: aggregate.NewID(aggregate.ResourceType_CPU, "tracedplanner")}
+
+	local, err := network.Connect(aggregate.NodeID())
+	if err != nil {
+		t.Fatalf("failed to connect local node: %s", err)
+	}
+	peer, err := network.Connect("peerNode")
+	if err != nil {
+		t.Fatalf("failed to connect peer node: %s", err)
+	}
+	defer peer.Close()
+
+	m := NewMagicBus(context.Background(), WithTracer(tracer), WithTransport(local))
+	defer m.Shutdown()
+	defer local.Close()
+
+	for _, a := range []aggregate.Aggregate{&counterAggregate{id: id}, planner} {
+		if err := m.Register(a, true); err != nil {
+			t.Fatalf("failed to register %s: %s", a.AggregateID(), err)
+		}
+	}
+
+	// launch runs @cmd within a new trace, and returns the spans of the trace by name, once @n spans have ended.
+	launch := func(cmd *aggregate.Command, n int) (trace.Span, map[string]trace.SpanData) {
+		root := tracer.Start(trace.SpanContext{}, "test")
+		ctx, cancel := context.WithTimeout(trace.ContextWithSpan(context.Background(), root.Context()), time.Second)
+		defer cancel()
+
+		m.Launch(ctx, cmd)
+		for deadline := time.Now().Add(time.Second); len(exporter.Trace(root.Context().TraceID)) < n; {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: expected %d spans, got %v", cmd, n, exporter.Trace(root.Context().TraceID))
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		var spans = map[string]trace.SpanData{}
+		for _, s := range exporter.Trace(root.Context().TraceID) {
+			if mailbox, ok := s.Attributes["mailbox"]; ok {
+				s.Name += "@" + mailbox
+			}
+			spans[s.Name] = s
+		}
+		return root, spans
+	}
+
+	// 1. All spans of a command descend from the span passed to Launch.
+	cmd, _ := aggregate.NewLocalCommand(id, "increment")
+	root, spans := launch(cmd, 7)
+
+	for name, parent := range map[string]string{
+		"launch increment":               "",
+		"submit increment":               "launch increment",
+		"queue increment@bus":            "submit increment",
+		"route increment":                "submit increment",
+		"queue increment@" + id.String(): "submit increment",
+		"handle increment":               "submit increment",
+		"dispatch CommandDone":           "submit increment",
+	} {
+		expected := root.Context()
+		if parent != "" {
+			expected = spans[parent].Context
+		}
+
+		if s, ok := spans[name]; !ok {
+			t.Fatalf("missing span %q in %v", name, spans)
+		} else if s.Parent != expected {
+			t.Fatalf("%s: expected parent %q (%s), got %s", name, parent, expected, s.Parent)
+		} else if s.Err != nil || s.Duration() < 0 {
+			t.Fatalf("%s: unexpected outcome %s after %s", name, s.Err, s.Duration())
+		}
+	}
+	if agg := spans["handle increment"].Attributes["aggregate"]; agg != id.String() {
+		t.Fatalf("expected %s to be handled by %s, got %q", cmd, id, agg)
+	}
+
+	// 2. Spans of undeliverable commands fail.
+	cmd, _ = aggregate.NewLocalCommand(aggregate.NewID(aggregate.ResourceType_CPU, "untraced"), "increment")
+	_, spans = launch(cmd, 5)
+
+	for _, name := range []string{"launch increment", "route increment"} {
+		if err := spans[name].Err; fault.CodeOf(err) != fault.NotFound {
+			t.Fatalf("%s: expected NotFound, got %v", name, err)
+		}
+	}
+
+	// 3. Follow-up commands continue the trace of the handling of their predecessor.
+	cmd, _ = aggregate.NewLocalCommand(planner.id, "chain")
+	_, spans = launch(cmd, 14)
+
+	if s := spans["launch finish"]; s.Parent != spans["handle chain"].Context {
+		t.Fatalf("expected follow-up of %s to continue its handling, got parent %s", cmd, s.Parent)
+	}
+
+	// 4. The submit span travels with commands to remote buses.
+	cmd, _ = aggregate.NewCommand(id, aggregate.ID{Node: "peerNode", Type: aggregate.ResourceType_MEMORY}, "increment")
+	if err := m.Submit(cmd); err != nil {
+		t.Fatalf("failed to submit %s: %s", cmd, err)
+	}
+
+	select {
+	case msg := <-peer.Receive():
+		sc := trace.Extract(msg.Command.Metadata())
+		if s := exporter.Trace(sc.TraceID); len(s) != 1 || s[0].Context != sc || s[0].Attributes["remote"] != "true" {
+			t.Fatalf("expected %s to carry its submit span, got %s in %v", cmd, sc, s)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %s to reach peer", cmd)
+	}
+}
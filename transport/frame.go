@@ -0,0 +1,114 @@
+package transport
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/codec"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// maxFrameSize limits the size of a single frame, to guard against corrupted length headers.
+const maxFrameSize = 16 << 20
+
+// encodeFrame encodes @msg as frame: a 4-byte length header, followed by the serialized
+// codec.Envelope of @msg (see encodeMessage).
+func encodeFrame(msg Message) ([]byte, error) {
+	payload, err := encodeMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(len(payload)))
+	return append(b, payload...), nil
+}
+
+// encodeMessage serializes @msg as codec.Envelope. Hence the types of commands and events
+// to be received have to be made known via codec.RegisterCommand()/codec.RegisterEvent().
+func encodeMessage(msg Message) ([]byte, error) {
+	var env *codec.Envelope
+	var err error
+
+	if msg.Command != nil {
+		env, err = codec.EncodeCommand(msg.Command)
+	} else if msg.Event != nil {
+		env, err = codec.EncodeEvent(msg.Event)
+	} else {
+		return nil, fault.New(fault.Rejected, "attempt to send an empty message")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := codec.Marshal(env)
+	if err != nil {
+		return nil, fault.Wrapf(err, fault.Rejected, "failed to encode %s", msg)
+	} else if len(payload) > maxFrameSize {
+		return nil, fault.New(fault.Rejected, "%s exceeds maximum frame size (%d bytes)", msg, len(payload))
+	}
+	return payload, nil
+}
+
+// readFrame returns the payload of the next length-prefixed frame from @r.
+func readFrame(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxFrameSize {
+		return nil, fault.New(fault.Rejected, "frame size %d exceeds maximum (%d bytes)", n, maxFrameSize)
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// writeAck acknowledges the receipt of @count frames.
+func writeAck(w io.Writer, count uint32) error {
+	var buf [4]byte
+
+	binary.BigEndian.PutUint32(buf[:], count)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// decodeFrame turns a frame @payload back into a Message.
+// The envelope is returned along with decoding errors, unless the frame itself is garbled.
+func decodeFrame(payload []byte) (msg Message, env *codec.Envelope, err error) {
+	env, err = codec.Unmarshal(payload)
+	if err != nil {
+		return msg, nil, err
+	}
+
+	switch env.Kind {
+	case codec.KindCommand:
+		msg.Command, err = codec.DecodeCommand(env)
+	case codec.KindEvent:
+		msg.Event, err = codec.DecodeEvent(env)
+	default:
+		err = fault.New(fault.Rejected, "invalid envelope kind %q", env.Kind)
+	}
+	return msg, env, err
+}
+
+// undecodable returns the failed CommandDone which notifies the issuer of command @env
+// that it could not be decoded, due to @err.
+func undecodable(env *codec.Envelope, err error) event.Event {
+	var cd = &event.CommandDone{Src: env.Dst, Dst: env.Src, CmdID: env.ID, Desc: env.Type}
+
+	// The payload is lost, but the envelope still identifies the command and its metadata.
+	if cmd, cerr := aggregate.NewCommandWithID(env.ID, env.Src, env.Dst, env.Type); cerr == nil {
+		cd.Md = cmd.WithMetadata(env.Metadata).CausedMetadata()
+	}
+	cd.Fail(fault.Wrapf(err, fault.Rejected, "unable to decode %s", env.Type))
+	return cd
+}
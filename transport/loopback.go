@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"context"
+	"sync"
+
+	"github.com/eapache/channels"
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/codec"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// Loopback is an in-process network, which connects Transports by node ID.
+// It is intended for testing, and for simulating a cluster within one process.
+type Loopback struct {
+	mu    sync.Mutex
+	nodes map[string]*loopbackTransport
+}
+
+// NewLoopback returns a new, empty in-process network.
+func NewLoopback() *Loopback {
+	return &Loopback{nodes: map[string]*loopbackTransport{}}
+}
+
+// Connect returns the Transport of @node, attached to @l.
+// Messages are encoded synchronously into the receive queue of the destination node, and decoded on
+// receipt, just like on a real network: the types of commands and events have to be registered with
+// the codec package, and sender and receiver never share a command or event.
+func (l *Loopback) Connect(node string) (Transport, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.nodes[node]; ok {
+		return nil, fault.New(fault.Conflict, "node %q is already connected", node)
+	}
+
+	t := &loopbackTransport{
+		network: l,
+		node:    node,
+		queue:   channels.NewInfiniteChannel(),
+		inbox:   make(chan Message),
+	}
+	l.nodes[node] = t
+
+	go t.pump()
+	return t, nil
+}
+
+// deliver puts @msg, encoded, into the receive queue of @node. Encoding errors are returned to the sender.
+func (l *Loopback) deliver(ctx context.Context, node string, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	payload, err := encodeMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t, ok := l.nodes[node]
+	if !ok {
+		return fault.New(fault.Unavailable, "unable to send %s: node %q is not connected", msg, node)
+	}
+	t.queue.In() <- payload
+	return nil
+}
+
+// loopbackTransport is the Transport endpoint of a single node on a Loopback network.
+type loopbackTransport struct {
+	network *Loopback
+	node    string
+
+	// Incoming encoded messages, in order of arrival
+	queue *channels.InfiniteChannel
+	inbox chan Message
+}
+
+func (t *loopbackTransport) SendCommand(ctx context.Context, cmd *aggregate.Command) error {
+	if cmd == nil {
+		return fault.New(fault.Rejected, "attempt to send a nil command")
+	}
+	return t.network.deliver(ctx, cmd.Dest().Node, Message{Command: cmd})
+}
+
+func (t *loopbackTransport) SendEvent(ctx context.Context, evt event.Event) error {
+	if evt == nil {
+		return fault.New(fault.Rejected, "attempt to send a nil event")
+	}
+	return t.network.deliver(ctx, evt.Dest().Node, Message{Event: evt})
+}
+
+func (t *loopbackTransport) Receive() <-chan Message {
+	return t.inbox
+}
+
+// Close detaches @t from the network. Messages already queued are still delivered.
+func (t *loopbackTransport) Close() error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+
+	if t.network.nodes[t.node] != t {
+		return fault.New(fault.Unavailable, "node %q is not connected", t.node)
+	}
+	delete(t.network.nodes, t.node)
+	t.queue.Close()
+	return nil
+}
+
+// pump decodes queued messages onto the receive channel, until @t is closed.
+// The issuer of a command that can not be decoded is notified via a failed CommandDone.
+func (t *loopbackTransport) pump() {
+	defer close(t.inbox)
+
+	for payload := range t.queue.Out() {
+		msg, env, err := decodeFrame(payload.([]byte))
+		if err != nil {
+			logger.Errorf("%s: dropping message: %s", t.node, err)
+			if env == nil || env.Kind != codec.KindCommand {
+				continue
+			}
+			msg = Message{Event: undecodable(env, err)}
+		}
+		t.inbox <- msg
+	}
+}
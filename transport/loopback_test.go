@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// receiveLoopback waits for the next message on @t.
+func receiveLoopback(t *testing.T, tr Transport) Message {
+	select {
+	case msg := <-tr.Receive():
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for message")
+	}
+	panic("not reached")
+}
+
+func TestLoopback(t *testing.T) {
+	var network = NewLoopback()
+
+	a, err := network.Connect("nodeA")
+	if err != nil {
+		t.Fatalf("failed to connect nodeA: %s", err)
+	}
+	defer a.Close()
+
+	b, err := network.Connect("nodeB")
+	if err != nil {
+		t.Fatalf("failed to connect nodeB: %s", err)
+	}
+	defer b.Close()
+
+	src := aggregate.ID{Node: "nodeA", Type: aggregate.ResourceType_CPU}
+	dst := aggregate.ID{Node: "nodeB", Type: aggregate.ResourceType_MEMORY}
+
+	// 1. The receiver gets a copy of the command, not the command of the sender.
+	cmd, _ := aggregate.NewCommand(src, dst, tcpTestCommand{Seq: 1})
+	if err := a.SendCommand(context.Background(), cmd); err != nil {
+		t.Fatalf("failed to send %s: %s", cmd, err)
+	}
+
+	msg := receiveLoopback(t, b)
+	if msg.Command == nil || msg.Command == cmd {
+		t.Fatalf("expected a copy of %s, got %s", cmd, msg)
+	} else if msg.Command.ID() != cmd.ID() || msg.Command.Data() != cmd.Data() {
+		t.Fatalf("received %s does not match %s", msg.Command, cmd)
+	}
+
+	// 2. Encoding errors are reported to the sender.
+	cmd, _ = aggregate.NewCommand(src, dst, tcpUnencodable{})
+	if err := a.SendCommand(context.Background(), cmd); err == nil {
+		t.Fatalf("expected %s to fail encoding", cmd)
+	}
+
+	// 3. The receiver answers commands it can not decode with a failed CommandDone to the issuer.
+	cmd, _ = aggregate.NewCommand(src, dst, tcpUnregistered{N: 1})
+	if err := a.SendCommand(context.Background(), cmd); err != nil {
+		t.Fatalf("failed to send %s: %s", cmd, err)
+	}
+
+	msg = receiveLoopback(t, b)
+	if cd, ok := msg.Event.(*event.CommandDone); !ok {
+		t.Fatalf("expected a CommandDone, got %s", msg)
+	} else if cd.CmdID != cmd.ID() || cd.Dest() != src {
+		t.Fatalf("CommandDone does not match %s: %+v", cmd, cd)
+	} else if cd.Fault == nil || cd.Fault.Code != fault.Rejected {
+		t.Fatalf("expected %s to be rejected, got %v", cmd, cd.Result().Err)
+	}
+}
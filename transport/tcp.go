@@ -0,0 +1,417 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eapache/channels"
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/codec"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+const (
+	// Timeout for establishing a connection to a peer
+	dialTimeout = 5 * time.Second
+
+	// Initial and maximum wait time between reconnection attempts
+	minRedialIntvl = 100 * time.Millisecond
+	maxRedialIntvl = 10 * time.Second
+
+	// Size of the receive queue, before readers start applying backpressure
+	receiveQueueLen = 64
+
+	// Maximum number of messages per peer which are queued or not yet acknowledged, before sends fail
+	sendQueueLen = 10000
+)
+
+// TCP is a Transport which connects the local bus to the buses on other nodes via TCP.
+//
+// Each peer node is served by a single, persistent connection, which is
+// re-established on failure. Messages to the same peer are queued and sent
+// strictly in submission order (per-peer ordering); messages that have not been
+// acknowledged by the peer are re-sent on the next connection, hence delivery
+// is at-least-once. While a peer is unreachable, its messages are kept in memory,
+// up to sendQueueLen of them; further sends to it fail as fault.Unavailable.
+type TCP struct {
+	// Resolves node IDs into peer addresses
+	resolve Resolver
+
+	// Incoming connections
+	listener net.Listener
+
+	// Incoming messages
+	inbox chan Message
+
+	// Outgoing connections (map { address -> peer })
+	mu    sync.Mutex
+	peers map[string]*peer
+
+	// Cancellation context, which terminates all connections
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Tracks running goroutines
+	wg sync.WaitGroup
+
+	// Makes Close idempotent
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewTCP listens on @listenAddr for incoming messages, and sends outgoing messages to the peer
+// addresses returned by @resolve.
+func NewTCP(ctx context.Context, listenAddr string, resolve Resolver) (*TCP, error) {
+	if resolve == nil {
+		return nil, fault.New(fault.Rejected, "attempt to create TCP transport with nil Resolver")
+	}
+
+	l, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fault.Wrapf(err, fault.Unavailable, "failed to listen on %s", listenAddr)
+	}
+
+	t := &TCP{
+		resolve:  resolve,
+		listener: l,
+		inbox:    make(chan Message, receiveQueueLen),
+		peers:    map[string]*peer{},
+	}
+	t.ctx, t.cancel = context.WithCancel(ctx)
+
+	t.wg.Add(1)
+	go t.accept()
+
+	go func() { // Shut down when the parent context is canceled
+		<-t.ctx.Done()
+		t.listener.Close()
+	}()
+	return t, nil
+}
+
+// Addr returns the listening address of @t.
+func (t *TCP) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// SendCommand queues @cmd for delivery to the node of cmd.Dest().
+func (t *TCP) SendCommand(ctx context.Context, cmd *aggregate.Command) error {
+	if cmd == nil {
+		return fault.New(fault.Rejected, "attempt to send a nil command")
+	}
+	return t.send(ctx, cmd.Dest().Node, Message{Command: cmd})
+}
+
+// SendEvent queues @evt for delivery to the node of evt.Dest().
+func (t *TCP) SendEvent(ctx context.Context, evt event.Event) error {
+	if evt == nil {
+		return fault.New(fault.Rejected, "attempt to send a nil event")
+	}
+	return t.send(ctx, evt.Dest().Node, Message{Event: evt})
+}
+
+// Receive returns the stream of messages received from peers.
+// The channel is closed when @t shuts down.
+func (t *TCP) Receive() <-chan Message {
+	return t.inbox
+}
+
+// Close terminates all connections and waits for the internal goroutines to finish.
+// Subsequent calls return the result of the first one.
+func (t *TCP) Close() error {
+	t.closeOnce.Do(func() {
+		t.cancel()
+		err := t.listener.Close()
+
+		t.mu.Lock()
+		for _, p := range t.peers {
+			p.queue.Close()
+		}
+		t.mu.Unlock()
+
+		t.wg.Wait()
+		close(t.inbox)
+
+		if err != nil && t.ctx.Err() == nil {
+			t.closeErr = err
+		}
+	})
+	return t.closeErr
+}
+
+// send queues @msg on the connection to @node.
+// Messages are encoded right away, so that encoding errors are reported to the sender.
+func (t *TCP) send(ctx context.Context, node string, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	addr, err := t.resolve(node)
+	if err != nil {
+		return fault.Wrapf(err, fault.Unavailable, "unable to send %s", msg)
+	}
+
+	b, err := encodeFrame(msg)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Check under lock, since Close() closes the peer queues after canceling t.ctx.
+	if err = t.ctx.Err(); err != nil {
+		return fault.Wrapf(err, fault.Unavailable, "transport shut down")
+	}
+
+	p, ok := t.peers[addr]
+	if !ok {
+		p = newPeer(addr)
+		t.peers[addr] = p
+
+		t.wg.Add(1)
+		go t.writer(p)
+	}
+	if atomic.AddInt64(&p.pending, 1) > sendQueueLen {
+		atomic.AddInt64(&p.pending, -1)
+		return fault.New(fault.Unavailable, "unable to send %s: send queue of %s is full", msg, addr)
+	}
+	p.queue.In() <- outgoing{msg: msg, frame: b}
+	return nil
+}
+
+// accept handles incoming connections until the listener is closed.
+func (t *TCP) accept() {
+	defer t.wg.Done()
+
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			if t.ctx.Err() == nil {
+				logger.Errorf("failed to accept connection on %s: %s", t.Addr(), err)
+			}
+			return
+		}
+
+		t.wg.Add(1)
+		go t.reader(conn)
+	}
+}
+
+// reader receives messages from @conn until the connection is closed.
+func (t *TCP) reader(conn net.Conn) {
+	var done = make(chan struct{})
+
+	defer t.wg.Done()
+	defer conn.Close()
+	defer close(done)
+
+	go func() { // unblock the read below on shutdown
+		select {
+		case <-t.ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		payload, err := readFrame(r)
+		if err != nil {
+			if t.ctx.Err() == nil {
+				logger.Debugf("closing connection from %s: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		// Frame boundaries remain intact, hence a message that can not be decoded is skipped.
+		// The issuer of a command that can not be decoded is notified via a failed CommandDone.
+		msg, env, err := decodeFrame(payload)
+		if err != nil {
+			logger.Errorf("dropping message from %s: %s", conn.RemoteAddr(), err)
+			if env == nil || env.Kind != codec.KindCommand {
+				if err = writeAck(conn, 1); err != nil {
+					return
+				}
+				continue
+			}
+			msg = Message{Event: undecodable(env, err)}
+		}
+
+		select {
+		case t.inbox <- msg:
+		case <-t.ctx.Done():
+			return
+		}
+
+		if err = writeAck(conn, 1); err != nil {
+			logger.Debugf("closing connection from %s: %s", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// writer sends the messages queued for @p in order, (re-)connecting as needed.
+func (t *TCP) writer(p *peer) {
+	defer t.wg.Done()
+	defer p.disconnect()
+
+	for {
+		select {
+		case m, ok := <-p.queue.Out():
+			if !ok {
+				return
+			} else if t.ctx.Err() != nil {
+				// Keep reading until the queue is closed, to terminate the goroutine of the InfiniteChannel.
+				continue
+			}
+
+			out := m.(outgoing)
+			p.unacked = append(p.unacked, out.frame)
+
+			if p.conn != nil {
+				if err := p.write(out.frame); err != nil {
+					logger.Warningf("%s: failed to send %s: %s", p.addr, out.msg, err)
+					p.disconnect()
+				}
+			}
+			if p.conn == nil {
+				t.connect(p)
+			}
+		case a := <-p.acks:
+			if a.conn == p.conn { // ignore stale acknowledgments from previous connections
+				p.unacked = p.unacked[a.count:]
+				atomic.AddInt64(&p.pending, -int64(a.count))
+			}
+		case conn := <-p.broken:
+			if conn == p.conn {
+				logger.Warningf("%s: connection lost", p.addr)
+				p.disconnect()
+				t.connect(p)
+			}
+		}
+	}
+}
+
+// connect establishes a new connection for @p, and re-sends all unacknowledged frames.
+// Retries with exponential backoff until it succeeds, or @t shuts down.
+func (t *TCP) connect(p *peer) {
+	for redial := minRedialIntvl; t.ctx.Err() == nil; redial *= 2 {
+		err := p.dial(t.ctx)
+		for i := 0; err == nil && i < len(p.unacked); i++ {
+			err = p.write(p.unacked[i])
+		}
+		if err == nil {
+			return
+		}
+		logger.Warningf("%s: failed to connect: %s", p.addr, err)
+		p.disconnect()
+
+		if redial > maxRedialIntvl {
+			redial = maxRedialIntvl
+		}
+		select {
+		case <-time.After(redial):
+		case <-t.ctx.Done():
+		}
+	}
+}
+
+// peer is the outgoing connection to a remote bus.
+//
+// Each frame is acknowledged by the receiver once it has been queued on the
+// receiving side. Frames are kept until acknowledged, and are re-sent in the
+// original order after a reconnect.
+type peer struct {
+	// Number of messages queued or not yet acknowledged (atomic).
+	// Atomically accessed fields come first, to guarantee 64-bit alignment.
+	pending int64
+
+	// Network address of the remote bus
+	addr string
+
+	// Outgoing messages, in submission order
+	queue *channels.InfiniteChannel
+
+	// Current connection, nil if not connected
+	conn net.Conn
+
+	// Encoded frames sent on, but not yet acknowledged by the current connection
+	unacked [][]byte
+
+	// Acknowledgments and connection failures, reported by ackReader()
+	acks   chan ack
+	broken chan net.Conn
+}
+
+// outgoing is a message queued for a peer, along with its encoded frame.
+type outgoing struct {
+	msg   Message
+	frame []byte
+}
+
+// ack acknowledges the receipt of @count frames on @conn.
+type ack struct {
+	conn  net.Conn
+	count uint32
+}
+
+func newPeer(addr string) *peer {
+	return &peer{
+		addr:   addr,
+		queue:  channels.NewInfiniteChannel(),
+		acks:   make(chan ack),
+		broken: make(chan net.Conn),
+	}
+}
+
+// dial connects @p to its remote address.
+func (p *peer) dial(ctx context.Context) (err error) {
+	if p.conn, err = net.DialTimeout("tcp", p.addr, dialTimeout); err != nil {
+		return err
+	}
+	go p.ackReader(ctx, p.conn)
+	return nil
+}
+
+// ackReader reports acknowledgments received on @conn, until @conn fails.
+func (p *peer) ackReader(ctx context.Context, conn net.Conn) {
+	var buf [4]byte
+
+	for {
+		if _, err := io.ReadFull(conn, buf[:]); err != nil {
+			select {
+			case p.broken <- conn:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		select {
+		case p.acks <- ack{conn: conn, count: binary.BigEndian.Uint32(buf[:])}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// write sends @frame on the current connection.
+func (p *peer) write(frame []byte) error {
+	_, err := p.conn.Write(frame)
+	return err
+}
+
+// disconnect closes the current connection of @p, if any.
+func (p *peer) disconnect() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
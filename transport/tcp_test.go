@@ -0,0 +1,200 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/codec"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// Test command
+type tcpTestCommand struct {
+	Seq int
+}
+
+func init() {
+	if err := codec.RegisterCommand(tcpTestCommand{}); err != nil {
+		panic(err)
+	}
+}
+
+// newTestTCP returns a TCP transport on a random local port, which resolves @peers.
+func newTestTCP(t *testing.T, peers map[string]string) *TCP {
+	tcp, err := NewTCP(context.Background(), "127.0.0.1:0", StaticResolver(peers))
+	if err != nil {
+		t.Fatalf("failed to create TCP transport: %s", err)
+	}
+	return tcp
+}
+
+// receive waits for the next message on @tcp.
+func receive(t *testing.T, tcp *TCP) Message {
+	select {
+	case msg := <-tcp.Receive():
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for message on %s", tcp.Addr())
+	}
+	panic("not reached")
+}
+
+func TestTCPOrdering(t *testing.T) {
+	const numCmds = 500
+	var peers = map[string]string{}
+
+	a, b := newTestTCP(t, peers), newTestTCP(t, peers)
+	defer a.Close()
+	defer b.Close()
+
+	peers["nodeA"], peers["nodeB"] = a.Addr().String(), b.Addr().String()
+
+	src := aggregate.ID{Node: "nodeA", Type: aggregate.ResourceType_CPU}
+	dst := aggregate.ID{Node: "nodeB", Type: aggregate.ResourceType_MEMORY, ID: "bank3"}
+
+	for i := 0; i < numCmds; i++ {
+		cmd, err := aggregate.NewCommand(src, dst, tcpTestCommand{Seq: i})
+		if err != nil {
+			t.Fatalf("failed to create command: %s", err)
+		} else if err = a.SendCommand(context.Background(), cmd); err != nil {
+			t.Fatalf("failed to send %s: %s", cmd, err)
+		}
+	}
+
+	for i := 0; i < numCmds; i++ {
+		msg := receive(t, b)
+		if msg.Command == nil {
+			t.Fatalf("expected a command, got %s", msg)
+		} else if msg.Command.Source() != src || msg.Command.Dest() != dst {
+			t.Fatalf("command addresses garbled: %s => %s", msg.Command.Source(), msg.Command.Dest())
+		} else if seq := msg.Command.Data().(tcpTestCommand).Seq; seq != i {
+			t.Fatalf("out-of-order delivery: expected #%d, got #%d", i, seq)
+		}
+	}
+
+	// Reply with an event in the other direction
+	if err := b.SendEvent(context.Background(), &event.CommandDone{Src: dst, Dst: src, Desc: "tcpTestCommand"}); err != nil {
+		t.Fatalf("failed to send event: %s", err)
+	} else if msg := receive(t, a); msg.Event == nil {
+		t.Fatalf("expected an event, got %s", msg)
+	} else if cd, ok := msg.Event.(*event.CommandDone); !ok || cd.Dest() != src || cd.Desc != "tcpTestCommand" {
+		t.Fatalf("unexpected event %v", msg.Event)
+	}
+}
+
+func TestTCPReconnect(t *testing.T) {
+	var peers = map[string]string{}
+	var dst = aggregate.ID{Node: "nodeB", Type: aggregate.ResourceType_CPU}
+
+	a, b := newTestTCP(t, peers), newTestTCP(t, peers)
+	defer a.Close()
+
+	peers["nodeB"] = b.Addr().String()
+
+	send := func(seq int) {
+		cmd, _ := aggregate.NewLocalCommand(dst, tcpTestCommand{Seq: seq})
+		if err := a.SendCommand(context.Background(), cmd); err != nil {
+			t.Fatalf("failed to send command #%d: %s", seq, err)
+		}
+	}
+
+	send(1)
+	if msg := receive(t, b); msg.Command == nil {
+		t.Fatalf("expected a command, got %s", msg)
+	}
+
+	// Restart the peer on the same address: queued messages must arrive once it is back.
+	b.Close()
+	send(2)
+
+	b, err := NewTCP(context.Background(), peers["nodeB"], StaticResolver(peers))
+	if err != nil {
+		t.Fatalf("failed to restart peer on %s: %s", peers["nodeB"], err)
+	}
+	defer b.Close()
+
+	for msg := receive(t, b); ; msg = receive(t, b) {
+		if seq := msg.Command.Data().(tcpTestCommand).Seq; seq == 2 {
+			break
+		} else if seq != 1 { // #1 may be re-sent, since delivery is at-least-once
+			t.Fatalf("unexpected command %s", fmt.Sprint(msg.Command.Data()))
+		}
+	}
+}
+
+// Test commands which can not be encoded, and which the receiver can not decode (unregistered)
+type tcpUnencodable struct {
+	C chan int
+}
+
+type tcpUnregistered struct {
+	N int
+}
+
+func TestTCPUndeliverable(t *testing.T) {
+	var peers = map[string]string{}
+
+	a, b := newTestTCP(t, peers), newTestTCP(t, peers)
+	defer a.Close()
+	defer b.Close()
+
+	peers["nodeA"], peers["nodeB"] = a.Addr().String(), b.Addr().String()
+
+	src := aggregate.ID{Node: "nodeA", Type: aggregate.ResourceType_CPU}
+	dst := aggregate.ID{Node: "nodeB", Type: aggregate.ResourceType_MEMORY}
+
+	// 1. Encoding errors are reported to the sender.
+	cmd, _ := aggregate.NewCommand(src, dst, tcpUnencodable{})
+	if err := a.SendCommand(context.Background(), cmd); err == nil {
+		t.Fatalf("expected %s to fail encoding", cmd)
+	}
+
+	// 2. The receiver answers commands it can not decode with a failed CommandDone to the issuer.
+	cmd, _ = aggregate.NewCommand(src, dst, tcpUnregistered{N: 1})
+	if err := a.SendCommand(context.Background(), cmd); err != nil {
+		t.Fatalf("failed to send %s: %s", cmd, err)
+	}
+
+	msg := receive(t, b)
+	if cd, ok := msg.Event.(*event.CommandDone); !ok {
+		t.Fatalf("expected a CommandDone, got %s", msg)
+	} else if cd.CmdID != cmd.ID() || cd.Dest() != src || cd.Source() != dst {
+		t.Fatalf("CommandDone does not match %s: %+v", cmd, cd)
+	} else if cd.Fault == nil || cd.Fault.Code != fault.Rejected {
+		t.Fatalf("expected %s to be rejected, got %v", cmd, cd.Result().Err)
+	} else if cd.Metadata().CorrelationID() != cmd.ID() || cd.Metadata().CausationID() != cmd.ID() {
+		t.Fatalf("expected CommandDone to be caused by %s, got %v", cmd, cd.Metadata())
+	}
+}
+
+func TestTCPClose(t *testing.T) {
+	var unreachable = aggregate.ID{Node: "nodeB", Type: aggregate.ResourceType_CPU}
+
+	// Listen on a free port, and stop listening, so that connections to it are refused.
+	b := newTestTCP(t, nil)
+	b.Close()
+
+	a := newTestTCP(t, map[string]string{"nodeB": b.Addr().String()})
+
+	// The messages to an unreachable peer are bounded.
+	for i := 0; ; i++ {
+		cmd, _ := aggregate.NewLocalCommand(unreachable, tcpTestCommand{Seq: i})
+		if err := a.SendCommand(context.Background(), cmd); err == nil {
+			continue
+		} else if i != sendQueueLen || fault.CodeOf(err) != fault.Unavailable {
+			t.Fatalf("unexpected failure of command #%d: %s", i, err)
+		}
+		break
+	}
+
+	// Closing twice is harmless.
+	if err := a.Close(); err != nil {
+		t.Fatalf("failed to close: %s", err)
+	} else if err = a.Close(); err != nil {
+		t.Fatalf("failed to close again: %s", err)
+	}
+}
@@ -0,0 +1,69 @@
+// Package transport moves Commands and Events between MagicBus instances on different nodes.
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/grrtrr/magicbus/aggregate"
+	"github.com/grrtrr/magicbus/event"
+	"github.com/grrtrr/magicbus/fault"
+)
+
+// GLOBAL VARIABLES
+var logger = logrus.WithField("module", "transport")
+
+// Transport connects a bus to the buses on other nodes.
+type Transport interface {
+	// SendCommand forwards @cmd to the bus on the node of cmd.Dest()
+	SendCommand(ctx context.Context, cmd *aggregate.Command) error
+
+	// SendEvent forwards @evt to the bus on the node of evt.Dest()
+	SendEvent(ctx context.Context, evt event.Event) error
+
+	// Receive returns the stream of messages received from other buses.
+	// The channel is closed after the Transport has been closed.
+	Receive() <-chan Message
+
+	// Close shuts down the Transport
+	Close() error
+}
+
+// Message is the unit of transfer between buses: exactly one of Command or Event is set.
+type Message struct {
+	Command *aggregate.Command
+	Event   event.Event
+}
+
+func (m Message) String() string {
+	if m.Command != nil {
+		return fmt.Sprintf("command %s => %s", m.Command, m.Command.Dest())
+	}
+	return fmt.Sprintf("event %v => %s", m.Event, m.Event.Dest())
+}
+
+// Resolver maps the Node of an aggregate.ID onto the network address of the bus on that node.
+type Resolver func(node string) (addr string, err error)
+
+// PortResolver treats the node ID as host name or IP address, with all buses listening on @port.
+func PortResolver(port int) Resolver {
+	return func(node string) (string, error) {
+		if node == "" {
+			return "", fault.New(fault.Rejected, "unable to resolve empty node ID")
+		}
+		return net.JoinHostPort(node, strconv.Itoa(port)), nil
+	}
+}
+
+// StaticResolver looks up the address of each node in @peers (map { NodeID -> host:port }).
+func StaticResolver(peers map[string]string) Resolver {
+	return func(node string) (string, error) {
+		if addr, ok := peers[node]; ok {
+			return addr, nil
+		}
+		return "", fault.New(fault.NotFound, "no address known for node %q", node)
+	}
+}